@@ -2,21 +2,48 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	_ "github.com/jackc/pgx/stdlib"
 	"github.com/jmoiron/sqlx"
+	config "github.com/practice-sem-2/user-service/internal/configs"
+	gateway "github.com/practice-sem-2/user-service/internal/gateways"
+	hasher "github.com/practice-sem-2/user-service/internal/hashers"
+	metric "github.com/practice-sem-2/user-service/internal/metrics"
+	notifier "github.com/practice-sem-2/user-service/internal/notifiers"
+	otp "github.com/practice-sem-2/user-service/internal/otps"
 	"github.com/practice-sem-2/user-service/internal/pb"
+	publisher "github.com/practice-sem-2/user-service/internal/publishers"
+	request "github.com/practice-sem-2/user-service/internal/requests"
 	"github.com/practice-sem-2/user-service/internal/server"
 	storage "github.com/practice-sem-2/user-service/internal/storages"
+	token "github.com/practice-sem-2/user-service/internal/tokens"
 	"github.com/practice-sem-2/user-service/internal/usecases"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 )
 
 func initLogger(level string) *logrus.Logger {
@@ -42,23 +69,499 @@ func initLogger(level string) *logrus.Logger {
 	return logger
 }
 
-func initDB(dsn string, logger *logrus.Logger) *sqlx.DB {
-	db, err := sqlx.Connect("pgx", dsn)
+// initDB retries to connect with backoff for up to cfg.ConnectTimeout,
+// so the service may be started before database is ready
+func initDB(cfg config.DBConfig, logger *logrus.Logger) *sqlx.DB {
+	db, err := sqlx.Open("pgx", cfg.DSN)
 	if err != nil {
 		logger.Fatalf("can't connect to database: %s", err.Error())
 	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	err = db.Ping()
+	deadline := time.Now().Add(cfg.ConnectTimeout)
+	delay := 500 * time.Millisecond
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			break
+		}
+		if time.Now().Add(delay).After(deadline) {
+			logger.Fatalf("database ping failed: %s", err.Error())
+		}
 
-	if err != nil {
-		logger.Fatalf("database ping failed: %s", err.Error())
+		logger.Warningf("database ping failed, retrying in %s: %s", delay, err.Error())
+		time.Sleep(delay)
+		if delay *= 2; delay > 10*time.Second {
+			delay = 10 * time.Second
+		}
 	}
 
 	logger.Info("successfully connected to database")
 	return db
 }
 
-func initServer(address string, useCases *usecase.UseCase, logger *logrus.Logger) (*grpc.Server, net.Listener) {
+func initNotifier(kind string, cfg config.SMTPConfig, logger *logrus.Logger) notifier.Notifier {
+	switch kind {
+	case "smtp":
+		return notifier.NewSMTPNotifier(notifier.SMTPConfig{
+			Host:     cfg.Host,
+			Port:     cfg.Port,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			From:     cfg.From,
+		})
+	case "log":
+		return notifier.NewLogNotifier(logger)
+	default:
+		logger.Fatalf("unknown notifier: %s", kind)
+		return nil
+	}
+}
+
+// initTracerProvider sets global tracer provider and returns its shutdown,
+// which flushes spans. OTLP exporter is configured by OTEL_EXPORTER_OTLP_* env
+func initTracerProvider(ctx context.Context, cfg config.TracesConfig, logger *logrus.Logger) func(context.Context) error {
+	exporter := cfg.Exporter
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "otlp":
+		spanExporter, err = otlptracegrpc.New(ctx)
+	case "stdout":
+		spanExporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "none":
+		return func(context.Context) error { return nil }
+	default:
+		logger.Fatalf("unknown traces exporter: %s", exporter)
+	}
+	if err != nil {
+		logger.Fatalf("can't create %s traces exporter: %s", exporter, err.Error())
+	}
+
+	// Service name can be overridden with OTEL_SERVICE_NAME
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("user-service")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		logger.Fatalf("can't create traces resource: %s", err.Error())
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	logger.WithField("exporter", exporter).Info("tracing is enabled")
+	return provider.Shutdown
+}
+
+func initPublisher(cfg *config.Config, logger *logrus.Logger) publisher.Publisher {
+	switch kind := cfg.Publisher; kind {
+	case "kafka":
+		return publisher.NewKafkaPublisher(publisher.KafkaConfig{
+			Brokers: cfg.Kafka.Brokers,
+			Topic:   cfg.Kafka.Topic,
+		})
+	case "nats":
+		p, err := publisher.NewNATSPublisher(publisher.NATSConfig{
+			URL:           cfg.NATS.URL,
+			SubjectPrefix: cfg.NATS.SubjectPrefix,
+		})
+		if err != nil {
+			logger.Fatalf("can't connect to nats: %s", err.Error())
+		}
+		return p
+	case "log":
+		return publisher.NewLogPublisher(logger)
+	default:
+		logger.Fatalf("unknown publisher: %s", cfg.Publisher)
+		return nil
+	}
+}
+
+// relayOutbox publishes pending events until the outbox is
+// drained and then waits for the next tick
+func relayOutbox(ctx context.Context, relay *usecase.OutboxRelay, interval time.Duration, batchSize int, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for {
+				published, err := relay.Relay(ctx)
+				if err != nil {
+					logger.Errorf("can't publish outbox events: %s", err.Error())
+					break
+				}
+				if published < batchSize {
+					break
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// listenChanges keeps connection listening for changes and reconnects
+// if it fails. Every notification wakes up the change feed
+func listenChanges(ctx context.Context, dsn string, wake chan<- struct{}, logger *logrus.Logger) {
+	notify := func() {
+		select {
+		case wake <- struct{}{}:
+		default:
+			// Feed is going to poll anyway
+		}
+	}
+
+	for {
+		err := storage.ListenChanges(ctx, dsn, notify)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Errorf("listening for user changes failed, reconnecting: %s", err.Error())
+
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// feedChanges polls changes whenever they are notified, and also periodically
+// in case notification is lost. Old changes are pruned along the way
+func feedChanges(ctx context.Context, feed *usecase.ChangeFeed, wake <-chan struct{}, interval time.Duration, retention time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-wake:
+		case <-ticker.C:
+		case <-prune.C:
+			if _, err := feed.Prune(ctx, retention); err != nil {
+				logger.Errorf("can't prune user changes: %s", err.Error())
+			}
+			continue
+		case <-ctx.Done():
+			return
+		}
+
+		if err := feed.Poll(ctx); err != nil {
+			logger.Errorf("can't poll user changes: %s", err.Error())
+		}
+	}
+}
+
+// purgeDeletedUsers removes users whose deletion grace period is over
+func purgeDeletedUsers(ctx context.Context, users *usecase.UserUseCase, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			purged, err := users.PurgeDeleted(ctx)
+			if err != nil {
+				logger.Errorf("can't purge deleted users: %s", err.Error())
+			}
+			if purged > 0 {
+				logger.WithField("users", purged).Info("purged deleted users")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// initSecretCipher returns nil if no key is set, which disables 2FA
+func initSecretCipher(encoded string, logger *logrus.Logger) usecase.SecretCipher {
+	if encoded == "" {
+		logger.Warning("TOTP_ENCRYPTION_KEY is not set, two-factor authentication is disabled")
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		logger.Fatalf("can't decode TOTP_ENCRYPTION_KEY: %s", err.Error())
+	}
+
+	cipher, err := otp.NewSecretCipher(key)
+	if err != nil {
+		logger.Fatalf("can't initialize TOTP secret cipher: %s", err.Error())
+	}
+	return cipher
+}
+
+func initTokenKeys(cfg config.TokenConfig, logger *logrus.Logger) *token.KeySet {
+	if dir := cfg.KeysDir; dir != "" {
+		keys, err := token.LoadKeySet(dir, cfg.KeyActivationDelay)
+		if err != nil {
+			logger.Fatalf("can't load token signing keys: %s", err.Error())
+		}
+		logger.
+			WithField("kid", keys.Current().ID).
+			WithField("keys", len(keys.Keys())).
+			Info("loaded token signing keys")
+		return keys
+	}
+
+	path := cfg.KeyFile
+	if path == "" {
+		logger.Warning("neither TOKEN_KEYS_DIR nor TOKEN_KEY_FILE is set, tokens are signed with a temporary key")
+		key, err := token.GenerateKey()
+		if err != nil {
+			logger.Fatalf("can't generate token signing key: %s", err.Error())
+		}
+		return token.NewStaticKeySet(key)
+	}
+
+	key, err := token.LoadKey(path)
+	if err != nil {
+		logger.Fatalf("can't load token signing key: %s", err.Error())
+	}
+	logger.
+		WithField("kid", key.ID).
+		WithField("alg", key.Method.Alg()).
+		Info("loaded token signing key")
+	return token.NewStaticKeySet(key)
+}
+
+// reloadTokenKeys periodically rereads key directory,
+// so keys can be rotated without restart
+func reloadTokenKeys(ctx context.Context, keys *token.KeySet, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	current := keys.Current().ID
+	for {
+		select {
+		case <-ticker.C:
+			if err := keys.Reload(); err != nil {
+				logger.Errorf("can't reload token signing keys: %s", err.Error())
+				continue
+			}
+			if id := keys.Current().ID; id != current {
+				logger.WithField("kid", id).Info("token signing key rotated")
+				current = id
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func initJWKSServer(address string, keys *token.KeySet, logger *logrus.Logger) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/.well-known/jwks.json", token.NewJWKSHandler(keys))
+
+	srv := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		logger.Infof("start serving jwks on %s", address)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatalf("jwks serving error: %s", err.Error())
+		}
+	}()
+	return srv
+}
+
+// initServerCredentials returns nil if TLS_CERT_FILE is not set, then server
+// accepts plaintext connections and clients can't authenticate with certificates
+func initServerCredentials(cfg config.TLSConfig, logger *logrus.Logger) credentials.TransportCredentials {
+	if cfg.CertFile == "" {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		logger.Fatalf("can't load TLS certificate: %s", err.Error())
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile := cfg.ClientCAFile; caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			logger.Fatalf("can't read TLS client CA: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			logger.Fatalf("no certificates found in %s", caFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return credentials.NewTLS(tlsConfig)
+}
+
+// initGatewayCredentials mirrors initServerCredentials. Server certificate is
+// verified against GATEWAY_TLS_CA_FILE, or system roots if it is not set
+func initGatewayCredentials(cfg *config.Config, logger *logrus.Logger) credentials.TransportCredentials {
+	if cfg.TLS.CertFile == "" {
+		return insecure.NewCredentials()
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: cfg.Gateway.TLSServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile := cfg.Gateway.TLSCAFile; caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			logger.Fatalf("can't read gateway TLS CA: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			logger.Fatalf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return credentials.NewTLS(tlsConfig)
+}
+
+// initGatewayServer serves REST gateway, which calls grpc server at target
+func initGatewayServer(ctx context.Context, address string, target string, cfg *config.Config, logger *logrus.Logger) (*http.Server, *grpc.ClientConn) {
+	conn, err := grpc.DialContext(ctx, target,
+		grpc.WithTransportCredentials(initGatewayCredentials(cfg, logger)),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+	if err != nil {
+		logger.Fatalf("can't dial grpc server for gateway: %s", err.Error())
+	}
+
+	srv := &http.Server{
+		Addr:              address,
+		Handler:           gateway.New(pb.NewUserClient(conn)).Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		logger.Infof("start serving REST gateway on %s", address)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatalf("gateway serving error: %s", err.Error())
+		}
+	}()
+	return srv, conn
+}
+
+// stopServer waits for running calls until ctx is done and then closes
+// connections. Health watch streams never end, so GracefulStop alone would
+// wait for them forever
+func stopServer(ctx context.Context, srv *grpc.Server, logger *logrus.Logger) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Warning("graceful shutdown timed out, closing remaining connections")
+		srv.Stop()
+	}
+}
+
+// parseServiceRoles parses "identity=role,identity=role" into roles by identity
+func parseServiceRoles(value string) (map[string][]string, error) {
+	roles := make(map[string][]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 || i == len(pair)-1 {
+			return nil, fmt.Errorf("invalid service role %q, expected identity=role", pair)
+		}
+		identity, role := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		roles[identity] = append(roles[identity], role)
+	}
+	return roles, nil
+}
+
+// initMetricsServer serves health probes along with metrics for load
+// balancers and probes that don't speak grpc.health.v1
+func initMetricsServer(address string, metrics *metric.Metrics, health *server.Health, logger *logrus.Logger) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/livez", health.LivezHandler())
+	mux.Handle("/readyz", health.ReadyzHandler())
+
+	srv := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		logger.Infof("start serving metrics on %s", address)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatalf("metrics serving error: %s", err.Error())
+		}
+	}()
+	return srv
+}
+
+// checkDatabase marks service as not ready while database doesn't respond
+func checkDatabase(ctx context.Context, db *sqlx.DB, health *server.Health, interval time.Duration, logger *logrus.Logger) {
+	check := func() {
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		defer cancel()
+
+		err := db.PingContext(pingCtx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && health.Ready() {
+			logger.Errorf("database ping failed, service is not ready: %s", err.Error())
+		} else if err == nil && !health.Ready() {
+			logger.Info("database is reachable, service is ready")
+		}
+		health.SetReady(err == nil)
+	}
+
+	check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func initServer(address string, cfg *config.Config, useCases *usecase.UseCase, keys *token.KeySet, verifier server.TokenVerifier, metrics *metric.Metrics, health *server.Health, logger *logrus.Logger) (*grpc.Server, net.Listener) {
 
 	listener, err := net.Listen("tcp", address)
 	logger.Infof("start listening on %s", address)
@@ -67,30 +570,98 @@ func initServer(address string, useCases *usecase.UseCase, logger *logrus.Logger
 		logger.Fatalf("can't listen to address: %s", err.Error())
 	}
 
-	grpcServer := grpc.NewServer()
-	pb.RegisterUserServer(grpcServer, server.NewUserServer(useCases))
+	serverConfig := cfg.Server()
+	auth := server.NewAuthInterceptor(verifier, useCases.Access, serverConfig)
+
+	observer := server.NewMetricsInterceptor(metrics)
+	logging := server.NewLoggingInterceptor(logger, serverConfig)
+	errs := server.NewErrorInterceptor()
+	recovery := server.NewRecoveryInterceptor()
+
+	// Metrics and logging go first to see final status, errors
+	// interceptor goes before recovery to log panics turned into errors
+	opts := []grpc.ServerOption{
+		// Stats handler starts server spans from trace context of incoming metadata
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(observer.Unary(), logging.Unary(), errs.Unary(), recovery.Unary(), auth.Unary()),
+		grpc.ChainStreamInterceptor(observer.Stream(), logging.Stream(), errs.Stream(), recovery.Stream(), auth.Stream()),
+	}
+	if creds := initServerCredentials(cfg.TLS, logger); creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterUserServer(grpcServer, server.NewUserServer(useCases, keys, serverConfig))
+	healthpb.RegisterHealthServer(grpcServer, health.Server())
+	if cfg.GRPCReflection {
+		reflection.Register(grpcServer)
+		logger.Info("grpc reflection is enabled")
+	}
 
 	return grpcServer, listener
 }
 
+// flagKeys are config keys set by flags
+var flagKeys = map[string]string{
+	"host":         "host",
+	"port":         "port",
+	"log":          "log_level",
+	"jwks-port":    "jwks.port",
+	"metrics-port": "metrics.port",
+	"gateway-port": "gateway.port",
+}
+
 func main() {
-	viper.AutomaticEnv()
-	ctx := context.Background()
-	defer ctx.Done()
+	defaults := config.Default()
 
-	var host string
-	var port int
-	var logLevel string
+	var configPath string
+	var printConfig bool
 
-	flag.IntVar(&port, "port", 80, "port on which server will be started")
-	flag.StringVar(&host, "host", "0.0.0.0", "host on which server will be started")
-	flag.StringVar(&logLevel, "log", "info", "log level")
+	flag.StringVar(&configPath, "config", os.Getenv("CONFIG_FILE"), "path to YAML or TOML config file, which is overridden by environment and flags")
+	flag.BoolVar(&printConfig, "print-config", false, "print resolved config with secrets redacted and exit")
+	flag.Int("port", defaults.Port, "port on which server will be started")
+	flag.String("host", defaults.Host, "host on which server will be started")
+	flag.Int("jwks-port", defaults.JWKS.Port, "port on which jwks will be served over http, 0 disables it")
+	flag.Int("metrics-port", defaults.Metrics.Port, "port on which prometheus metrics and /livez, /readyz probes will be served over http, 0 disables them")
+	flag.Int("gateway-port", defaults.Gateway.Port, "port on which REST gateway will be started, 0 disables it")
+	flag.String("log", defaults.LogLevel, "log level")
 
 	flag.Parse()
 
-	logger := initLogger(logLevel)
+	overrides := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		if key, ok := flagKeys[f.Name]; ok {
+			overrides[key] = f.Value.String()
+		}
+	})
 
-	db := initDB(viper.GetString("DB_DSN"), logger)
+	cfg, err := config.Load(configPath, overrides)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if printConfig {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := initLogger(cfg.LogLevel)
+	// Background jobs get the same logger, which requests get from interceptor
+	ctx = request.WithLogger(ctx, logrus.NewEntry(logger))
+
+	db := initDB(cfg.DB, logger)
 	defer func(db *sqlx.DB) {
 		err := db.Close()
 		if err != nil {
@@ -98,11 +669,84 @@ func main() {
 		}
 	}(db)
 
-	store := storage.NewStorage(db)
-	useCases := usecase.NewUseCase(store)
+	passwordHasher, err := hasher.NewNamedHasher(cfg.PasswordHasher, hasher.Argon2idParams(cfg.Argon2id), cfg.Bcrypt.Cost)
+	if err != nil {
+		logger.Fatalf("can't initialize password hasher: %s", err.Error())
+	}
+
+	useCaseConfig := cfg.UseCase()
+	useCaseConfig.ServiceRoles, err = parseServiceRoles(cfg.ServiceRoles)
+	if err != nil {
+		logger.Fatalf("can't parse SERVICE_ROLES: %s", err.Error())
+	}
+
+	keys := initTokenKeys(cfg.Token, logger)
+	if cfg.Token.KeysDir != "" {
+		go reloadTokenKeys(ctx, keys, cfg.Token.KeysReloadInterval, logger)
+	}
+
+	issuer := token.NewIssuer(keys, cfg.Token.Issuer, cfg.AccessTokenTTL)
+
+	metrics := metric.New(db.DB)
+
+	shutdownTracing := initTracerProvider(ctx, cfg.Traces, logger)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Errorf("can't flush spans: %s", err.Error())
+		}
+	}()
+
+	store := storage.NewStorage(db, cfg.Storage())
+	useCases := usecase.NewUseCase(store, passwordHasher, initNotifier(cfg.Notifier, cfg.SMTP, logger), initSecretCipher(cfg.TOTP.EncryptionKey, logger), issuer, metrics, useCaseConfig)
+
+	eventPublisher := initPublisher(cfg, logger)
+	defer func(p publisher.Publisher) {
+		if err := p.Close(); err != nil {
+			logger.Errorf("can't close event publisher: %s", err.Error())
+		}
+	}(eventPublisher)
+
+	batchSize := cfg.Outbox.BatchSize
+	relay := usecase.NewOutboxRelay(store, eventPublisher, uint64(batchSize))
+	go relayOutbox(ctx, relay, cfg.Outbox.RelayInterval, batchSize, logger)
+
+	wake := make(chan struct{}, 1)
+	go listenChanges(ctx, cfg.DB.DSN, wake, logger)
+	go feedChanges(ctx, useCases.Changes, wake, cfg.Changes.PollInterval, cfg.Changes.Retention, logger)
+
+	go purgeDeletedUsers(ctx, useCases.Users, cfg.PurgeInterval, logger)
+
+	address := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	verifier := token.NewVerifier(keys, cfg.Token.Issuer)
+	health := server.NewHealth()
+	go checkDatabase(ctx, db, health, cfg.HealthCheckInterval, logger)
+
+	srv, lis := initServer(address, cfg, useCases, keys, verifier, metrics, health, logger)
+
+	var jwksSrv *http.Server
+	if cfg.JWKS.Port != 0 {
+		jwksSrv = initJWKSServer(fmt.Sprintf("%s:%d", cfg.Host, cfg.JWKS.Port), keys, logger)
+	}
+
+	var metricsSrv *http.Server
+	if cfg.Metrics.Port != 0 {
+		metricsSrv = initMetricsServer(fmt.Sprintf("%s:%d", cfg.Host, cfg.Metrics.Port), metrics, health, logger)
+	}
+
+	var gatewaySrv *http.Server
+	if cfg.Gateway.Port != 0 {
+		if !cfg.TrustProxyHeaders || len(cfg.TrustedProxies) == 0 {
+			logger.Warning("gateway is enabled, but proxy headers are not trusted, so all REST clients share one address")
+		}
+		var conn *grpc.ClientConn
+		gatewaySrv, conn = initGatewayServer(ctx, fmt.Sprintf("%s:%d", cfg.Host, cfg.Gateway.Port), lis.Addr().String(), cfg, logger)
+		defer func() {
+			_ = conn.Close()
+		}()
+	}
 
-	address := fmt.Sprintf("%s:%d", host, port)
-	srv, lis := initServer(address, useCases, logger)
 	osSignal := make(chan os.Signal, 1)
 	signal.Notify(osSignal,
 		syscall.SIGHUP,
@@ -110,18 +754,41 @@ func main() {
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
 
+	stopped := make(chan struct{})
 	go func(ctx context.Context) {
+		defer close(stopped)
 		select {
 		case sig := <-osSignal:
-			srv.GracefulStop()
 			logger.Infof("%s caught. Gracefully shutdown", sig.String())
+			// Probes fail from now on, but requests are still served
+			// until load balancers notice that and stop routing them here
+			health.Shutdown()
+			time.Sleep(cfg.Shutdown.DrainDelay)
+
+			cancel()
+			useCases.Changes.Close()
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+			defer cancelShutdown()
+			if jwksSrv != nil {
+				_ = jwksSrv.Shutdown(shutdownCtx)
+			}
+			if gatewaySrv != nil {
+				_ = gatewaySrv.Shutdown(shutdownCtx)
+			}
+			if metricsSrv != nil {
+				_ = metricsSrv.Shutdown(shutdownCtx)
+			}
+			stopServer(shutdownCtx, srv, logger)
+			useCases.Users.WaitNotifications()
 		case <-ctx.Done():
 			return
 		}
 	}(ctx)
 
-	err := srv.Serve(lis)
+	err = srv.Serve(lis)
 	if err != nil {
 		logger.Fatalf("grpc serving error: %s", err.Error())
 	}
+	// Serve returns as soon as server is stopped, before shutdown is done
+	<-stopped
 }
@@ -0,0 +1,396 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	hasher "github.com/practice-sem-2/user-service/internal/hashers"
+	"github.com/practice-sem-2/user-service/internal/server"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	usecase "github.com/practice-sem-2/user-service/internal/usecases"
+	"github.com/spf13/viper"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Config is the whole configuration of the service. Keys are nested with
+// dots in files and with underscores in environment, e.g. db.dsn is DB_DSN
+type Config struct {
+	Host     string `mapstructure:"host" validate:"required"`
+	Port     int    `mapstructure:"port" validate:"min=1,max=65535"`
+	LogLevel string `mapstructure:"log_level" validate:"oneof=trace debug info warn warning error fatal panic"`
+
+	DB           DBConfig           `mapstructure:"db"`
+	TLS          TLSConfig          `mapstructure:"tls"`
+	Gateway      GatewayConfig      `mapstructure:"gateway"`
+	Metrics      MetricsConfig      `mapstructure:"metrics"`
+	Shutdown     ShutdownConfig     `mapstructure:"shutdown"`
+	JWKS         JWKSConfig         `mapstructure:"jwks"`
+	Traces       TracesConfig       `mapstructure:"traces"`
+	Token        TokenConfig        `mapstructure:"token"`
+	Argon2id     Argon2idConfig     `mapstructure:"argon2id"`
+	Bcrypt       BcryptConfig       `mapstructure:"bcrypt"`
+	SMTP         SMTPConfig         `mapstructure:"smtp"`
+	Kafka        KafkaConfig        `mapstructure:"kafka"`
+	NATS         NATSConfig         `mapstructure:"nats"`
+	Outbox       OutboxConfig       `mapstructure:"outbox"`
+	Changes      ChangesConfig      `mapstructure:"changes"`
+	Activation   ActivationConfig   `mapstructure:"activation"`
+	EmailChange  EmailChangeConfig  `mapstructure:"email_change"`
+	Login        LoginConfig        `mapstructure:"login"`
+	TOTP         TOTPConfig         `mapstructure:"totp"`
+	SecondFactor SecondFactorConfig `mapstructure:"second_factor"`
+
+	// PasswordHasher is used for new hashes, the other one is still understood
+	PasswordHasher string `mapstructure:"password_hasher" validate:"oneof=argon2id bcrypt"`
+	Notifier       string `mapstructure:"notifier" validate:"oneof=log smtp"`
+	Publisher      string `mapstructure:"publisher" validate:"oneof=log kafka nats"`
+	// CodeSecret is a key used to hash one-time codes at rest. Without it
+	// codes could be brute-forced from database dump
+	CodeSecret string `mapstructure:"code_secret" validate:"required,min=32" secret:"true"`
+	// ServiceRoles is "identity=role,identity=role"
+	ServiceRoles             string        `mapstructure:"service_roles"`
+	TrustProxyHeaders        bool          `mapstructure:"trust_proxy_headers"`
+	TrustForwardedClientCert bool          `mapstructure:"trust_forwarded_client_cert"`
+	GRPCReflection           bool          `mapstructure:"grpc_reflection"`
+	HealthCheckInterval      time.Duration `mapstructure:"health_check_interval" validate:"gt=0"`
+	// TrustedProxies are CIDRs of proxies in front of the service
+	TrustedProxies []string `mapstructure:"trusted_proxies" validate:"dive,cidr"`
+
+	AccessTokenTTL       time.Duration `mapstructure:"access_token_ttl" validate:"gt=0"`
+	RefreshTokenTTL      time.Duration `mapstructure:"refresh_token_ttl" validate:"gtfield=AccessTokenTTL"`
+	PasswordResetTTL     time.Duration `mapstructure:"password_reset_ttl" validate:"gt=0"`
+	PasswordResetDelay   time.Duration `mapstructure:"password_reset_delay" validate:"min=0"`
+	AccountLockThreshold int           `mapstructure:"account_lock_threshold" validate:"min=1"`
+	IPLockThreshold      int           `mapstructure:"ip_lock_threshold" validate:"min=1"`
+	LockDuration         time.Duration `mapstructure:"lock_duration" validate:"gt=0"`
+	RecoveryCodes        int           `mapstructure:"recovery_codes" validate:"min=1,max=100"`
+	WatchBuffer          int           `mapstructure:"watch_buffer" validate:"min=1"`
+	DeletionGracePeriod  time.Duration `mapstructure:"deletion_grace_period" validate:"min=0"`
+	PurgeInterval        time.Duration `mapstructure:"purge_interval" validate:"gt=0"`
+}
+
+// DBConfig sets no limit on open connections and their lifetime by default
+type DBConfig struct {
+	DSN             string        `mapstructure:"dsn" validate:"required" secret:"true"`
+	MaxOpenConns    int           `mapstructure:"max_open_conns" validate:"min=0"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns" validate:"min=0"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime" validate:"min=0"`
+	ConnMaxIdleTime time.Duration `mapstructure:"conn_max_idle_time" validate:"min=0"`
+	// ConnectTimeout is how long startup waits for database to become available
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout" validate:"min=0"`
+	// QueryTimeout caps each query, RPC deadline is used if it is earlier
+	QueryTimeout time.Duration `mapstructure:"query_timeout" validate:"min=0"`
+	// MaxRetries of transactions failed with serialization errors or connection resets
+	MaxRetries int `mapstructure:"max_retries" validate:"min=0,max=10"`
+}
+
+// TLSConfig is disabled unless CertFile is set
+type TLSConfig struct {
+	CertFile     string `mapstructure:"cert_file" validate:"omitempty,file"`
+	KeyFile      string `mapstructure:"key_file" validate:"required_with=CertFile,omitempty,file"`
+	ClientCAFile string `mapstructure:"client_ca_file" validate:"omitempty,file"`
+}
+
+// Ports set to 0 disable corresponding servers. Gateway passes addresses
+// of its clients in x-forwarded-for, so TrustProxyHeaders must be set and
+// TrustedProxies must include address gateway connects from
+type GatewayConfig struct {
+	Port          int    `mapstructure:"port" validate:"min=0,max=65535"`
+	TLSCAFile     string `mapstructure:"tls_ca_file" validate:"omitempty,file"`
+	TLSServerName string `mapstructure:"tls_server_name"`
+}
+
+// MetricsConfig port also serves /livez and /readyz, so disabling it
+// leaves only grpc.health.v1 on the main port for probes
+type MetricsConfig struct {
+	Port int `mapstructure:"port" validate:"min=0,max=65535"`
+}
+
+type ShutdownConfig struct {
+	// DrainDelay is how long server keeps serving after probes start
+	// failing, so that load balancers stop routing requests to it
+	DrainDelay time.Duration `mapstructure:"drain_delay" validate:"min=0"`
+	// Timeout is how long running calls may take before they are
+	// cancelled. Health watch streams never end on their own
+	Timeout time.Duration `mapstructure:"timeout" validate:"gt=0"`
+}
+
+type JWKSConfig struct {
+	Port int `mapstructure:"port" validate:"min=0,max=65535"`
+}
+
+type TracesConfig struct {
+	Exporter    string  `mapstructure:"exporter" validate:"oneof=otlp stdout none"`
+	SampleRatio float64 `mapstructure:"sample_ratio" validate:"min=0,max=1"`
+}
+
+type TokenConfig struct {
+	Issuer string `mapstructure:"issuer" validate:"required"`
+	// KeysDir takes precedence over KeyFile. Names of keys in it start
+	// with publication time, like 20240131T120000Z.pem
+	KeysDir            string        `mapstructure:"keys_dir" validate:"omitempty,dir"`
+	KeyFile            string        `mapstructure:"key_file" validate:"omitempty,file"`
+	KeyActivationDelay time.Duration `mapstructure:"key_activation_delay" validate:"min=0"`
+	KeysReloadInterval time.Duration `mapstructure:"keys_reload_interval" validate:"gt=0"`
+}
+
+type Argon2idConfig struct {
+	// Memory in KiB
+	Memory      uint32 `mapstructure:"memory" validate:"min=8192"`
+	Iterations  uint32 `mapstructure:"iterations" validate:"min=1"`
+	Parallelism uint8  `mapstructure:"parallelism" validate:"min=1"`
+	SaltLength  uint32 `mapstructure:"salt_length" validate:"min=16"`
+	KeyLength   uint32 `mapstructure:"key_length" validate:"min=16"`
+}
+
+type BcryptConfig struct {
+	Cost int `mapstructure:"cost" validate:"min=10,max=31"`
+}
+
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port" validate:"min=1,max=65535"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password" secret:"true"`
+	From     string `mapstructure:"from"`
+}
+
+type KafkaConfig struct {
+	Brokers []string `mapstructure:"brokers"`
+	Topic   string   `mapstructure:"topic"`
+}
+
+type NATSConfig struct {
+	URL           string `mapstructure:"url"`
+	SubjectPrefix string `mapstructure:"subject_prefix"`
+}
+
+type OutboxConfig struct {
+	RelayInterval time.Duration `mapstructure:"relay_interval" validate:"gt=0"`
+	BatchSize     int           `mapstructure:"batch_size" validate:"min=1"`
+}
+
+type ChangesConfig struct {
+	PollInterval time.Duration `mapstructure:"poll_interval" validate:"gt=0"`
+	Retention    time.Duration `mapstructure:"retention" validate:"gt=0"`
+}
+
+type ActivationConfig struct {
+	CodeTTL        time.Duration `mapstructure:"code_ttl" validate:"gt=0"`
+	ResendCooldown time.Duration `mapstructure:"resend_cooldown" validate:"min=0"`
+	MaxAttempts    int           `mapstructure:"max_attempts" validate:"min=1"`
+}
+
+type EmailChangeConfig struct {
+	TTL         time.Duration `mapstructure:"ttl" validate:"gt=0"`
+	MaxAttempts int           `mapstructure:"max_attempts" validate:"min=1"`
+}
+
+type LoginConfig struct {
+	FreeAttempts   int           `mapstructure:"free_attempts" validate:"min=0"`
+	BaseDelay      time.Duration `mapstructure:"base_delay" validate:"min=0"`
+	MaxDelay       time.Duration `mapstructure:"max_delay" validate:"gtefield=BaseDelay"`
+	AttemptsWindow time.Duration `mapstructure:"attempts_window" validate:"gt=0"`
+}
+
+type TOTPConfig struct {
+	Issuer string `mapstructure:"issuer" validate:"required"`
+	// EncryptionKey is base64 encoded, two-factor authentication is disabled without it
+	EncryptionKey string `mapstructure:"encryption_key" validate:"omitempty,base64" secret:"true"`
+}
+
+type SecondFactorConfig struct {
+	ChallengeTTL time.Duration `mapstructure:"challenge_ttl" validate:"gt=0"`
+	// MaxAttempts is per challenge, LockThreshold is per account
+	MaxAttempts   int `mapstructure:"max_attempts" validate:"min=1"`
+	LockThreshold int `mapstructure:"lock_threshold" validate:"min=1"`
+}
+
+// Default returns configuration that is used for keys which are set nowhere
+func Default() Config {
+	uc := usecase.DefaultConfig
+	return Config{
+		Host:     "0.0.0.0",
+		Port:     80,
+		LogLevel: "info",
+		DB: DBConfig{
+			MaxIdleConns:   2,
+			ConnectTimeout: time.Minute,
+			QueryTimeout:   storage.DefaultConfig.QueryTimeout,
+			MaxRetries:     storage.DefaultConfig.MaxRetries,
+		},
+		Gateway:  GatewayConfig{Port: 8081},
+		Metrics:  MetricsConfig{Port: 9090},
+		Shutdown: ShutdownConfig{DrainDelay: 5 * time.Second, Timeout: 30 * time.Second},
+		Traces:   TracesConfig{Exporter: "none", SampleRatio: 1},
+		Token: TokenConfig{
+			Issuer:             "user-service",
+			KeyActivationDelay: time.Hour,
+			KeysReloadInterval: time.Minute,
+		},
+		Argon2id: Argon2idConfig(hasher.DefaultArgon2idParams),
+		Bcrypt:   BcryptConfig{Cost: hasher.DefaultBcryptCost},
+		SMTP:     SMTPConfig{Port: 587},
+		Kafka:    KafkaConfig{Topic: "users"},
+		NATS:     NATSConfig{URL: "nats://127.0.0.1:4222", SubjectPrefix: "users"},
+		Outbox:   OutboxConfig{RelayInterval: time.Second, BatchSize: 100},
+		Changes:  ChangesConfig{PollInterval: 10 * time.Second, Retention: 7 * 24 * time.Hour},
+		Activation: ActivationConfig{
+			CodeTTL:        uc.ActivationCodeTTL,
+			ResendCooldown: uc.ActivationResendCooldown,
+			MaxAttempts:    uc.ActivationMaxAttempts,
+		},
+		EmailChange: EmailChangeConfig{TTL: uc.EmailChangeTTL, MaxAttempts: uc.EmailChangeMaxAttempts},
+		Login: LoginConfig{
+			FreeAttempts:   uc.Lockout.FreeAttempts,
+			BaseDelay:      uc.Lockout.BaseDelay,
+			MaxDelay:       uc.Lockout.MaxDelay,
+			AttemptsWindow: uc.Lockout.Window,
+		},
+		TOTP: TOTPConfig{Issuer: uc.TOTPIssuer},
+		SecondFactor: SecondFactorConfig{
+			ChallengeTTL:  uc.ChallengeTTL,
+			MaxAttempts:   uc.ChallengeMaxAttempts,
+			LockThreshold: uc.Lockout.SecondFactorLockThreshold,
+		},
+		PasswordHasher:       "argon2id",
+		Notifier:             "log",
+		Publisher:            "log",
+		HealthCheckInterval:  5 * time.Second,
+		AccessTokenTTL:       15 * time.Minute,
+		RefreshTokenTTL:      uc.RefreshTokenTTL,
+		PasswordResetTTL:     uc.PasswordResetTTL,
+		PasswordResetDelay:   uc.PasswordResetDelay,
+		AccountLockThreshold: uc.Lockout.AccountLockThreshold,
+		IPLockThreshold:      uc.Lockout.IPLockThreshold,
+		LockDuration:         uc.Lockout.LockDuration,
+		RecoveryCodes:        uc.RecoveryCodes,
+		WatchBuffer:          uc.WatchBuffer,
+		DeletionGracePeriod:  uc.DeletionGracePeriod,
+		PurgeInterval:        time.Hour,
+	}
+}
+
+// Load resolves configuration from defaults, file at path if it is not
+// empty, environment and overrides, each one taking precedence over the
+// previous. Overrides are keyed the same way as in file
+func Load(path string, overrides map[string]string) (*Config, error) {
+	v := viper.New()
+	for key, value := range Values(Default(), false) {
+		v.SetDefault(key, value)
+	}
+
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	if path != "" {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("can't read config file: %w", err)
+		}
+	}
+
+	for key, value := range overrides {
+		v.Set(key, value)
+	}
+
+	config := &Config{}
+	if err := v.Unmarshal(config); err != nil {
+		return nil, fmt.Errorf("can't decode config: %w", err)
+	}
+	return config, nil
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	// Errors name keys the way they are set
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		return field.Tag.Get("mapstructure")
+	})
+	return v
+}
+
+func (c *Config) Validate() error {
+	err := validate.Struct(c)
+
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		// Namespace starts with the name of the root struct
+		_, key, _ := strings.Cut(e.Namespace(), ".")
+		messages[i] = fmt.Sprintf("%s: failed on %s", key, describeTag(e))
+	}
+	return fmt.Errorf("invalid config: %s", strings.Join(messages, "; "))
+}
+
+func describeTag(e validator.FieldError) string {
+	if e.Param() == "" {
+		return e.Tag()
+	}
+	return e.Tag() + "=" + e.Param()
+}
+
+// Server returns configuration of grpc server. Proxies are parsed
+// without checking errors, because Validate already checks them
+func (c *Config) Server() server.Config {
+	proxies := make([]*net.IPNet, 0, len(c.TrustedProxies))
+	for _, cidr := range c.TrustedProxies {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			proxies = append(proxies, network)
+		}
+	}
+
+	return server.Config{
+		TrustProxyHeaders:        c.TrustProxyHeaders,
+		TrustForwardedClientCert: c.TrustForwardedClientCert,
+		TrustedProxies:           proxies,
+	}
+}
+
+func (c *Config) Storage() storage.Config {
+	return storage.Config{
+		QueryTimeout: c.DB.QueryTimeout,
+		MaxRetries:   c.DB.MaxRetries,
+	}
+}
+
+// UseCase returns configuration of use cases, except for ServiceRoles,
+// which have to be parsed
+func (c *Config) UseCase() usecase.Config {
+	return usecase.Config{
+		CodeSecret:               c.CodeSecret,
+		ActivationCodeTTL:        c.Activation.CodeTTL,
+		ActivationResendCooldown: c.Activation.ResendCooldown,
+		ActivationMaxAttempts:    c.Activation.MaxAttempts,
+		PasswordResetTTL:         c.PasswordResetTTL,
+		PasswordResetDelay:       c.PasswordResetDelay,
+		EmailChangeTTL:           c.EmailChange.TTL,
+		EmailChangeMaxAttempts:   c.EmailChange.MaxAttempts,
+		RefreshTokenTTL:          c.RefreshTokenTTL,
+		Lockout: usecase.LockoutConfig{
+			FreeAttempts:              c.Login.FreeAttempts,
+			BaseDelay:                 c.Login.BaseDelay,
+			MaxDelay:                  c.Login.MaxDelay,
+			AccountLockThreshold:      c.AccountLockThreshold,
+			IPLockThreshold:           c.IPLockThreshold,
+			SecondFactorLockThreshold: c.SecondFactor.LockThreshold,
+			LockDuration:              c.LockDuration,
+			Window:                    c.Login.AttemptsWindow,
+		},
+		TOTPIssuer:           c.TOTP.Issuer,
+		ChallengeTTL:         c.SecondFactor.ChallengeTTL,
+		ChallengeMaxAttempts: c.SecondFactor.MaxAttempts,
+		RecoveryCodes:        c.RecoveryCodes,
+		WatchBuffer:          c.WatchBuffer,
+		DeletionGracePeriod:  c.DeletionGracePeriod,
+	}
+}
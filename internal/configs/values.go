@@ -0,0 +1,60 @@
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+	"io"
+	"reflect"
+	"time"
+)
+
+// Redacted replaces values of secrets, which are set
+const Redacted = "[REDACTED]"
+
+// Values returns flat map of config values by their keys, like "db.dsn"
+func Values(c Config, redact bool) map[string]interface{} {
+	values := map[string]interface{}{}
+	flatten(values, "", nested(reflect.ValueOf(c), redact))
+	return values
+}
+
+// Print writes resolved config as YAML, which can be used as config file
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(nested(reflect.ValueOf(*c), true)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func nested(v reflect.Value, redact bool) map[string]interface{} {
+	values := map[string]interface{}{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := field.Tag.Get("mapstructure")
+		value := v.Field(i)
+
+		switch {
+		case value.Kind() == reflect.Struct:
+			values[key] = nested(value, redact)
+		case redact && field.Tag.Get("secret") == "true" && !value.IsZero():
+			values[key] = Redacted
+		case value.Type() == reflect.TypeOf(time.Duration(0)):
+			// Parsed back by viper as well
+			values[key] = value.Interface().(time.Duration).String()
+		default:
+			values[key] = value.Interface()
+		}
+	}
+	return values
+}
+
+func flatten(dst map[string]interface{}, prefix string, src map[string]interface{}) {
+	for key, value := range src {
+		if m, ok := value.(map[string]interface{}); ok {
+			flatten(dst, prefix+key+".", m)
+			continue
+		}
+		dst[prefix+key] = value
+	}
+}
@@ -0,0 +1,209 @@
+package gateway
+
+import (
+	"context"
+	_ "embed"
+	"github.com/practice-sem-2/user-service/internal/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// maxBodySize is far more than any request of the service needs
+const maxBodySize = 1 << 20
+
+//go:embed openapi.json
+var openAPI []byte
+
+var (
+	marshaler   = protojson.MarshalOptions{UseProtoNames: true}
+	unmarshaler = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+// forwardedHeaders are passed to grpc server as metadata
+var forwardedHeaders = []string{"authorization", "x-request-id"}
+
+// Gateway exposes part of User service as REST/JSON. Requests are made
+// through grpc client, so they pass the same interceptors as grpc ones.
+// Server has to trust proxy headers to see addresses of REST clients,
+// otherwise they all share the address of the gateway
+type Gateway struct {
+	client pb.UserClient
+}
+
+func New(client pb.UserClient) *Gateway {
+	return &Gateway{client: client}
+}
+
+// Handler serves the routes and OpenAPI document at /v1/openapi.json
+func (g *Gateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/openapi.json", g.serveOpenAPI)
+	mux.HandleFunc("/v1/users", g.serveUsers)
+	mux.HandleFunc("/v1/users:batchGet", g.batchGetUsers)
+	mux.HandleFunc("/v1/users/", g.serveUser)
+	return mux
+}
+
+func (g *Gateway) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPI)
+}
+
+// serveUsers handles POST /v1/users and GET /v1/users?email=
+func (g *Gateway) serveUsers(w http.ResponseWriter, r *http.Request) {
+	ctx, header := outgoingContext(r)
+
+	switch r.Method {
+	case http.MethodPost:
+		req := &pb.CreateUserRequest{}
+		if err := readBody(w, r, req); err != nil {
+			writeError(w, err)
+			return
+		}
+		resp, err := g.client.CreateUser(ctx, req, grpc.Header(header))
+		writeResponse(w, *header, http.StatusCreated, resp, err)
+	case http.MethodGet:
+		email := r.URL.Query().Get("email")
+		if email == "" {
+			writeError(w, status.Error(codes.InvalidArgument, "email query parameter is required"))
+			return
+		}
+		resp, err := g.client.GetUser(ctx, &pb.GetUserRequest{Email: &email}, grpc.Header(header))
+		writeResponse(w, *header, http.StatusOK, resp, err)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// batchGetUsers handles GET /v1/users:batchGet?usernames=a&usernames=b
+func (g *Gateway) batchGetUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	ctx, header := outgoingContext(r)
+
+	req := &pb.GetManyUsersRequest{Usernames: r.URL.Query()["usernames"]}
+	resp, err := g.client.GetManyUsers(ctx, req, grpc.Header(header))
+	writeResponse(w, *header, http.StatusOK, resp, err)
+}
+
+// serveUser handles /v1/users/{username} and its custom methods
+func (g *Gateway) serveUser(w http.ResponseWriter, r *http.Request) {
+	username, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/users/"), ":")
+	if username == "" || strings.Contains(username, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	ctx, header := outgoingContext(r)
+
+	switch action {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			resp, err := g.client.GetUser(ctx, &pb.GetUserRequest{Username: &username}, grpc.Header(header))
+			writeResponse(w, *header, http.StatusOK, resp, err)
+		case http.MethodPatch:
+			req := &pb.UpdateUserRequest{}
+			if err := readBody(w, r, req); err != nil {
+				writeError(w, err)
+				return
+			}
+			req.Username = username
+			resp, err := g.client.UpdateUser(ctx, req, grpc.Header(header))
+			writeResponse(w, *header, http.StatusOK, resp, err)
+		case http.MethodDelete:
+			resp, err := g.client.DeleteUser(ctx, &pb.DeleteUserRequest{Username: username}, grpc.Header(header))
+			writeResponse(w, *header, http.StatusOK, resp, err)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPatch, http.MethodDelete)
+		}
+	case "activate":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		req := &pb.ActivateRequest{}
+		if err := readBody(w, r, req); err != nil {
+			writeError(w, err)
+			return
+		}
+		req.Username = username
+		resp, err := g.client.ActivateUser(ctx, req, grpc.Header(header))
+		writeResponse(w, *header, http.StatusOK, resp, err)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// outgoingContext passes credentials and client address to grpc server.
+// Returned metadata is filled with response header once call is done
+func outgoingContext(r *http.Request) (context.Context, *metadata.MD) {
+	md := metadata.MD{}
+	for _, key := range forwardedHeaders {
+		if values := r.Header.Values(key); len(values) > 0 {
+			md.Set(key, values...)
+		}
+	}
+
+	// Header sent by client is dropped, so that it can't
+	// choose the address login attempts are counted for
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		md.Set("x-forwarded-for", host)
+	}
+
+	return metadata.NewOutgoingContext(r.Context(), md), &metadata.MD{}
+}
+
+func readBody(w http.ResponseWriter, r *http.Request, m proto.Message) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "can't read request body")
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	if err = unmarshaler.Unmarshal(body, m); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid request body: %s", err.Error())
+	}
+	return nil
+}
+
+func writeResponse(w http.ResponseWriter, header metadata.MD, code int, resp proto.Message, err error) {
+	if values := header.Get("x-request-id"); len(values) > 0 {
+		w.Header().Set("X-Request-Id", values[0])
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, code, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, m proto.Message) {
+	body, err := marshaler.Marshal(m)
+	if err != nil {
+		code = http.StatusInternalServerError
+		body = []byte(`{"code":13,"message":"internal error"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, "method not allowed").Proto())
+}
@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/practice-sem-2/user-service/internal/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeClient records the last request, methods not overridden panic
+type fakeClient struct {
+	pb.UserClient
+	request  proto.Message
+	metadata metadata.MD
+	err      error
+}
+
+func (c *fakeClient) record(ctx context.Context, req proto.Message, opts []grpc.CallOption) {
+	c.request = req
+	c.metadata, _ = metadata.FromOutgoingContext(ctx)
+	for _, opt := range opts {
+		if h, ok := opt.(grpc.HeaderCallOption); ok {
+			*h.HeaderAddr = metadata.Pairs("x-request-id", "42")
+		}
+	}
+}
+
+func (c *fakeClient) CreateUser(ctx context.Context, req *pb.CreateUserRequest, opts ...grpc.CallOption) (*pb.CreateUserResponse, error) {
+	c.record(ctx, req, opts)
+	return &pb.CreateUserResponse{User: &pb.UserData{Username: req.Username, Email: req.Email}}, c.err
+}
+
+func (c *fakeClient) GetUser(ctx context.Context, req *pb.GetUserRequest, opts ...grpc.CallOption) (*pb.GetUserResponse, error) {
+	c.record(ctx, req, opts)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &pb.GetUserResponse{User: &pb.UserData{Username: req.GetUsername(), Email: req.GetEmail()}}, nil
+}
+
+func (c *fakeClient) GetManyUsers(ctx context.Context, req *pb.GetManyUsersRequest, opts ...grpc.CallOption) (*pb.GetManyUsersResponse, error) {
+	c.record(ctx, req, opts)
+	return &pb.GetManyUsersResponse{Missing: req.Usernames}, c.err
+}
+
+func (c *fakeClient) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest, opts ...grpc.CallOption) (*pb.UpdateUserResponse, error) {
+	c.record(ctx, req, opts)
+	return &pb.UpdateUserResponse{User: &pb.UserData{Username: req.Username, FirstName: req.FirstName}}, c.err
+}
+
+func (c *fakeClient) ActivateUser(ctx context.Context, req *pb.ActivateRequest, opts ...grpc.CallOption) (*pb.ActivateResponse, error) {
+	c.record(ctx, req, opts)
+	return &pb.ActivateResponse{}, c.err
+}
+
+func serve(client *fakeClient, method string, target string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	New(client).Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGateway_CreateUser(t *testing.T) {
+	client := &fakeClient{}
+
+	rec := serve(client, http.MethodPost, "/v1/users", `{"username":"joe","password":"secret","email":"joe@example.com"}`)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "42", rec.Header().Get("X-Request-Id"))
+	assert.JSONEq(t, `{"user":{"username":"joe","email":"joe@example.com"}}`, rec.Body.String())
+	assert.Equal(t, []string{"Bearer token"}, client.metadata.Get("authorization"))
+	assert.Equal(t, []string{"192.0.2.1"}, client.metadata.Get("x-forwarded-for"))
+}
+
+func TestGateway_OverwritesForwardedAddress(t *testing.T) {
+	client := &fakeClient{}
+	req := httptest.NewRequest(http.MethodGet, "/v1/users/joe", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	req.Header.Set("X-Real-Ip", "203.0.113.9")
+
+	New(client).Handler().ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, []string{"192.0.2.1"}, client.metadata.Get("x-forwarded-for"))
+	assert.Empty(t, client.metadata.Get("x-real-ip"))
+}
+
+func TestGateway_Routes(t *testing.T) {
+	name := "Joe"
+	cases := []struct {
+		method  string
+		target  string
+		body    string
+		request proto.Message
+	}{
+		{http.MethodGet, "/v1/users/joe", "", &pb.GetUserRequest{Username: strPtr("joe")}},
+		{http.MethodGet, "/v1/users?email=joe@example.com", "", &pb.GetUserRequest{Email: strPtr("joe@example.com")}},
+		{http.MethodGet, "/v1/users:batchGet?usernames=joe&usernames=ann", "", &pb.GetManyUsersRequest{Usernames: []string{"joe", "ann"}}},
+		{http.MethodPatch, "/v1/users/joe", `{"username":"ann","first_name":"Joe"}`, &pb.UpdateUserRequest{Username: "joe", FirstName: &name}},
+		{http.MethodPost, "/v1/users/joe:activate", `{"code":"123456"}`, &pb.ActivateRequest{Username: "joe", Code: "123456"}},
+	}
+
+	for _, c := range cases {
+		client := &fakeClient{}
+		rec := serve(client, c.method, c.target, c.body)
+		assert.Equal(t, http.StatusOK, rec.Code, c.target)
+		assert.True(t, proto.Equal(c.request, client.request), c.target)
+	}
+}
+
+func TestGateway_UnknownRoutes(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, serve(&fakeClient{}, http.MethodPost, "/v1/users/joe:restore", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(&fakeClient{}, http.MethodGet, "/v1/users/joe/roles", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(&fakeClient{}, http.MethodPut, "/v1/users/joe", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(&fakeClient{}, http.MethodGet, "/v1/users", "").Code, "email is required")
+	assert.Equal(t, http.StatusBadRequest, serve(&fakeClient{}, http.MethodPost, "/v1/users", "{").Code)
+}
+
+func TestGateway_Errors(t *testing.T) {
+	st, _ := status.New(codes.NotFound, "user not found").WithDetails(&errdetails.ErrorInfo{Reason: "USER_NOT_FOUND"})
+	client := &fakeClient{err: st.Err()}
+
+	rec := serve(client, http.MethodGet, "/v1/users/joe", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	var body struct {
+		Code    int
+		Message string
+		Details []map[string]interface{}
+	}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body)) {
+		assert.Equal(t, int(codes.NotFound), body.Code)
+		assert.Equal(t, "user not found", body.Message)
+		if assert.Len(t, body.Details, 1) {
+			assert.Equal(t, "USER_NOT_FOUND", body.Details[0]["reason"])
+		}
+	}
+
+	client.err = status.Error(codes.Unauthenticated, "access token is invalid or expired")
+	rec = serve(client, http.MethodGet, "/v1/users/joe", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+}
+
+func TestHTTPStatus(t *testing.T) {
+	assert.Equal(t, http.StatusConflict, HTTPStatus(codes.AlreadyExists))
+	assert.Equal(t, http.StatusTooManyRequests, HTTPStatus(codes.ResourceExhausted))
+	assert.Equal(t, http.StatusForbidden, HTTPStatus(codes.PermissionDenied))
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(codes.Code(100)))
+}
+
+func TestOpenAPIIsValidJSON(t *testing.T) {
+	rec := serve(&fakeClient{}, http.MethodGet, "/v1/openapi.json", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, json.Valid(rec.Body.Bytes()))
+}
+
+func strPtr(s string) *string {
+	return &s
+}
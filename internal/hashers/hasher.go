@@ -0,0 +1,83 @@
+package hasher
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("password hash has unknown algorithm")
+	ErrMalformedHash    = errors.New("password hash is malformed")
+)
+
+// PasswordHasher produces and checks encoded password hashes.
+// Encoded hashes are self-describing (PHC string format), so
+// parameters can be changed without breaking existing hashes.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded hash was produced by another
+	// algorithm or with parameters different from the current ones
+	NeedsRehash(encoded string) bool
+}
+
+// Algorithm is a PasswordHasher that can recognize its own hashes
+type Algorithm interface {
+	PasswordHasher
+	Owns(encoded string) bool
+}
+
+// Hasher hashes new passwords with the current algorithm and verifies
+// old ones with whichever known algorithm has produced them
+type Hasher struct {
+	current Algorithm
+	known   []Algorithm
+}
+
+func NewHasher(current Algorithm, legacy ...Algorithm) *Hasher {
+	return &Hasher{
+		current: current,
+		known:   append([]Algorithm{current}, legacy...),
+	}
+}
+
+// NewDefaultHasher returns hasher that uses algorithm with provided name
+// (either "argon2id" or "bcrypt") for new hashes and understands all
+// hashes that were ever stored by the service
+func NewDefaultHasher(name string) (*Hasher, error) {
+	return NewNamedHasher(name, DefaultArgon2idParams, DefaultBcryptCost)
+}
+
+// NewNamedHasher is like NewDefaultHasher, but algorithms use provided
+// parameters. Hashes made with other parameters are rehashed on login
+func NewNamedHasher(name string, params Argon2idParams, bcryptCost int) (*Hasher, error) {
+	argon := NewArgon2id(params)
+	bcrypt := NewBcrypt(bcryptCost)
+	legacy := LegacySHA256{}
+
+	switch strings.ToLower(name) {
+	case "", "argon2id":
+		return NewHasher(argon, bcrypt, legacy), nil
+	case "bcrypt":
+		return NewHasher(bcrypt, argon, legacy), nil
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h *Hasher) Verify(password string, encoded string) (bool, error) {
+	for _, alg := range h.known {
+		if alg.Owns(encoded) {
+			return alg.Verify(password, encoded)
+		}
+	}
+	return false, ErrUnknownAlgorithm
+}
+
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	return !h.current.Owns(encoded) || h.current.NeedsRehash(encoded)
+}
@@ -0,0 +1,93 @@
+package hasher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+var testArgon2idParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2id_HashAndVerify(t *testing.T) {
+	a := NewArgon2id(testArgon2idParams)
+
+	hash, err := a.Hash("qwerty1")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), "Should produce PHC encoded hash")
+
+	ok, err := a.Verify("qwerty1", hash)
+	assert.Nil(t, err)
+	assert.True(t, ok, "Should accept correct password")
+
+	ok, err = a.Verify("qwerty2", hash)
+	assert.Nil(t, err)
+	assert.False(t, ok, "Should reject incorrect password")
+
+	assert.False(t, a.NeedsRehash(hash), "Should not rehash hash with the same parameters")
+	assert.True(t, NewArgon2id(DefaultArgon2idParams).NeedsRehash(hash), "Should rehash if parameters changed")
+}
+
+func TestBcrypt_HashAndVerify(t *testing.T) {
+	b := NewBcrypt(4)
+
+	hash, err := b.Hash("qwerty1")
+	assert.Nil(t, err)
+
+	ok, err := b.Verify("qwerty1", hash)
+	assert.Nil(t, err)
+	assert.True(t, ok, "Should accept correct password")
+
+	ok, err = b.Verify("qwerty2", hash)
+	assert.Nil(t, err)
+	assert.False(t, ok, "Should reject incorrect password")
+
+	assert.False(t, b.NeedsRehash(hash))
+	assert.True(t, NewBcrypt(5).NeedsRehash(hash), "Should rehash if cost changed")
+}
+
+func TestHasher_VerifiesLegacyHashesAndAsksForRehash(t *testing.T) {
+	h := NewHasher(NewArgon2id(testArgon2idParams), NewBcrypt(4), LegacySHA256{})
+	// Produced by the previous sha256.New().Sum([]byte("qwerty1")) implementation
+	legacy := "71776572747931e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	ok, err := h.Verify("qwerty1", legacy)
+	assert.Nil(t, err)
+	assert.True(t, ok, "Should accept correct password for legacy hash")
+	assert.True(t, h.NeedsRehash(legacy), "Should rehash legacy hash")
+
+	ok, err = h.Verify("qwerty2", legacy)
+	assert.Nil(t, err)
+	assert.False(t, ok, "Should reject incorrect password for legacy hash")
+}
+
+func TestHasher_VerifiesHashesOfNonCurrentAlgorithm(t *testing.T) {
+	bcryptHash, err := NewBcrypt(4).Hash("qwerty1")
+	assert.Nil(t, err)
+
+	h := NewHasher(NewArgon2id(testArgon2idParams), NewBcrypt(4), LegacySHA256{})
+
+	ok, err := h.Verify("qwerty1", bcryptHash)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, h.NeedsRehash(bcryptHash), "Should rehash hash of non-current algorithm")
+
+	hash, err := h.Hash("qwerty1")
+	assert.Nil(t, err)
+	assert.False(t, h.NeedsRehash(hash))
+}
+
+func TestHasher_RejectsMalformedHash(t *testing.T) {
+	h := NewHasher(NewArgon2id(testArgon2idParams))
+
+	_, err := h.Verify("qwerty1", "$argon2id$v=19$garbage")
+	assert.ErrorIs(t, err, ErrMalformedHash)
+
+	_, err = h.Verify("qwerty1", "$scrypt$whatever")
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
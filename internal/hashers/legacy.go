@@ -0,0 +1,33 @@
+package hasher
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// LegacySHA256 verifies hashes stored by the first versions of the service.
+// They were produced by sha256.New().Sum(password), which appends digest
+// of an empty input to the password, so they are neither salted nor
+// one-way. It must never be used to hash new passwords.
+type LegacySHA256 struct{}
+
+func (LegacySHA256) Hash(string) (string, error) {
+	return "", errors.New("legacy sha256 hashes must not be produced")
+}
+
+func (LegacySHA256) Verify(password string, encoded string) (bool, error) {
+	hasher := sha256.New()
+	expected := hex.EncodeToString(hasher.Sum([]byte(password)))
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(encoded)) == 1, nil
+}
+
+func (LegacySHA256) NeedsRehash(string) bool {
+	return true
+}
+
+func (LegacySHA256) Owns(encoded string) bool {
+	return !strings.HasPrefix(encoded, "$")
+}
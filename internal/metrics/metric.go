@@ -0,0 +1,103 @@
+package metric
+
+import (
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const namespace = "users"
+
+// Metrics are kept in own registry, so
+// several instances can coexist in tests
+type Metrics struct {
+	registry     *prometheus.Registry
+	requests     *prometheus.CounterVec
+	latency      *prometheus.HistogramVec
+	signups      prometheus.Counter
+	activations  prometheus.Counter
+	failedLogins prometheus.Counter
+	deletes      prometheus.Counter
+}
+
+// New registers runtime and process metrics, and stats of db pool if db is not nil
+func New(db *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "Number of RPCs completed on the server, regardless of success or failure.",
+		}, []string{"grpc_method", "grpc_code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Time it took the server to handle RPCs, for streams until they are closed.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_method", "grpc_code"}),
+		signups: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "signups_total",
+			Help:      "Number of created users.",
+		}),
+		activations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "activations_total",
+			Help:      "Number of activated users.",
+		}),
+		failedLogins: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "failed_logins_total",
+			Help:      "Number of logins with unknown username or wrong password.",
+		}),
+		deletes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "deletes_total",
+			Help:      "Number of deleted users.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.latency,
+		m.signups,
+		m.activations,
+		m.failedLogins,
+		m.deletes,
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, "users"))
+	}
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest takes full method, e.g. "/users.User/GetUser"
+func (m *Metrics) ObserveRequest(fullMethod string, code string, duration time.Duration) {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	m.requests.WithLabelValues(method, code).Inc()
+	m.latency.WithLabelValues(method, code).Observe(duration.Seconds())
+}
+
+func (m *Metrics) Signup() {
+	m.signups.Inc()
+}
+
+func (m *Metrics) Activation() {
+	m.activations.Inc()
+}
+
+func (m *Metrics) FailedLogin() {
+	m.failedLogins.Inc()
+}
+
+func (m *Metrics) Delete() {
+	m.deletes.Inc()
+}
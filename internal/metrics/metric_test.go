@@ -0,0 +1,35 @@
+package metric
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetrics_ObserveRequest(t *testing.T) {
+	m := New(nil)
+
+	m.ObserveRequest("/users.User/GetUser", "NotFound", 10*time.Millisecond)
+	m.ObserveRequest("/users.User/GetUser", "NotFound", 20*time.Millisecond)
+	m.ObserveRequest("/users.User/GetUser", "OK", time.Millisecond)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("GetUser", "NotFound")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("GetUser", "OK")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.latency))
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	m := New(nil)
+	m.Signup()
+	m.FailedLogin()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Body.String(), "users_signups_total 1")
+	assert.Contains(t, rec.Body.String(), "users_failed_logins_total 1")
+	assert.Contains(t, rec.Body.String(), "go_goroutines")
+}
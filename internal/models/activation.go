@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type ActivationCode struct {
+	ID        int64     `db:"id"`
+	Username  string    `db:"username"`
+	CodeHash  string    `db:"code_hash"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
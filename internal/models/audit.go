@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audited actions. Unlike events, they include changes
+// that are not published, like password or 2FA changes
+const (
+	AuditUserCreated            = "user.created"
+	AuditUserUpdated            = "user.updated"
+	AuditUserActivated          = "user.activated"
+	AuditActivationCodeSent     = "user.activation_code_sent"
+	AuditPasswordChanged        = "user.password_changed"
+	AuditPasswordResetRequested = "user.password_reset_requested"
+	AuditPasswordReset          = "user.password_reset"
+	AuditEmailChangeRequested   = "user.email_change_requested"
+	AuditEmailChanged           = "user.email_changed"
+	AuditUserUnlocked           = "user.unlocked"
+	AuditTOTPEnrolled           = "user.totp_enrolled"
+	AuditTOTPEnabled            = "user.totp_enabled"
+	AuditTOTPDisabled           = "user.totp_disabled"
+	AuditUserDeleted            = "user.deleted"
+	AuditUserRestored           = "user.restored"
+	AuditUserPurged             = "user.purged"
+	AuditRoleAssigned           = "role.assigned"
+	AuditRoleRevoked            = "role.revoked"
+)
+
+// Redacted replaces values of secret fields
+const Redacted = "[REDACTED]"
+
+// FieldChange has Old unset if field had no value
+// before and New unset if it has no value after
+type FieldChange struct {
+	Field string  `json:"field"`
+	Old   *string `json:"old,omitempty"`
+	New   *string `json:"new,omitempty"`
+}
+
+// AuditEvent is never changed once recorded. Actor is Principal of the
+// caller, "anonymous" for unauthenticated calls or "system" for changes
+// made by the service itself
+type AuditEvent struct {
+	ID          int64     `db:"id"`
+	Actor       string    `db:"actor"`
+	Username    string    `db:"username"`
+	Action      string    `db:"action"`
+	Changes     []byte    `db:"changes"`
+	RequestID   string    `db:"request_id"`
+	PeerAddress string    `db:"peer_address"`
+	OccurredAt  time.Time `db:"occurred_at"`
+}
+
+func (e *AuditEvent) DecodeChanges() ([]FieldChange, error) {
+	var changes []FieldChange
+	if err := json.Unmarshal(e.Changes, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// AuditQuery returns the newest events first. Empty filters match any event
+type AuditQuery struct {
+	Username string
+	Actor    string
+	Action   string
+	Since    *time.Time
+	Until    *time.Time
+	// BeforeID is ID of the last event of the previous page
+	BeforeID int64
+	Limit    uint64
+}
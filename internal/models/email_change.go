@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type EmailChange struct {
+	Username  string    `db:"username"`
+	NewEmail  string    `db:"new_email"`
+	CodeHash  string    `db:"code_hash"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventUserCreated   = "user.created"
+	EventUserUpdated   = "user.updated"
+	EventUserActivated = "user.activated"
+	EventUserDeleted   = "user.deleted"
+	EventUserRestored  = "user.restored"
+	// EventUserPurged follows EventUserDeleted once grace period is over
+	EventUserPurged = "user.purged"
+)
+
+// Event is a domain event kept in outbox until it is published.
+// Username is used as a key, so events of one user are delivered in order
+type Event struct {
+	ID        int64     `db:"id"`
+	Type      string    `db:"event_type"`
+	Username  string    `db:"username"`
+	Payload   []byte    `db:"payload"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// UserEventPayload is published as JSON. User is
+// the state after the change and is empty for deleted users
+type UserEventPayload struct {
+	Type       string        `json:"type"`
+	Username   string        `json:"username"`
+	OccurredAt time.Time     `json:"occurred_at"`
+	User       *UserSnapshot `json:"user,omitempty"`
+}
+
+// UserSnapshot never contains password hash
+type UserSnapshot struct {
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name,omitempty"`
+	LastName  string    `json:"last_name,omitempty"`
+	AvatarID  *string   `json:"avatar_id,omitempty"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewUserSnapshot(user *User) *UserSnapshot {
+	return &UserSnapshot{
+		Username:  user.Username,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		AvatarID:  user.AvatarID,
+		IsActive:  user.IsActive,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+// Change is a committed change of user. Seq grows in order of commits,
+// so watchers can resume after the last change they have seen
+type Change struct {
+	Seq       int64     `db:"seq"`
+	Type      string    `db:"event_type"`
+	Username  string    `db:"username"`
+	Payload   []byte    `db:"payload"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (c *Change) Decode() (*UserEventPayload, error) {
+	var payload UserEventPayload
+	if err := json.Unmarshal(c.Payload, &payload); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// UserFilter conditions are combined with AND, nil ones are ignored
+type UserFilter struct {
+	IsActive *bool
+	// EmailDomain matches email part after @, case-insensitive
+	EmailDomain *string
+	// NamePrefix matches beginning of username, first or last name, case-insensitive
+	NamePrefix    *string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// UserOrder sorts by Column and then by username,
+// so the order is stable even if values repeat
+type UserOrder struct {
+	Column string
+	Desc   bool
+}
+
+// UserCursor points to the last user of the previous page
+type UserCursor struct {
+	Value    string
+	Username string
+}
+
+type ListUsersQuery struct {
+	Filter UserFilter
+	Order  UserOrder
+	After  *UserCursor
+	Limit  uint64
+	// Columns to select, all of them if empty
+	Columns []string
+}
+
+type SearchUsersQuery struct {
+	Text   string
+	Filter UserFilter
+	After  *UserCursor
+	Limit  uint64
+	// Columns to select, all of them if empty
+	Columns []string
+}
+
+// UserMatch is user found by search. Rank is higher for better
+// matches, prefix matches always rank above similar ones
+type UserMatch struct {
+	User
+	Rank float64 `db:"rank"`
+}
+
+func (m *UserMatch) Cursor() UserCursor {
+	return UserCursor{
+		Value:    strconv.FormatFloat(m.Rank, 'g', -1, 64),
+		Username: m.Username,
+	}
+}
+
+// Cursor returns position of user in list sorted by column
+func (u *User) Cursor(column string) UserCursor {
+	var value string
+	switch column {
+	case "email":
+		value = u.Email
+	case "first_name":
+		value = u.FirstName
+	case "last_name":
+		value = u.LastName
+	case "created_at":
+		value = u.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return UserCursor{Value: value, Username: u.Username}
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type LoginAttempts struct {
+	Kind          string     `db:"kind"`
+	Subject       string     `db:"subject"`
+	Failures      int        `db:"failures"`
+	LastFailureAt time.Time  `db:"last_failure_at"`
+	LockedUntil   *time.Time `db:"locked_until"`
+}
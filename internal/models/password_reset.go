@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+type PasswordResetToken struct {
+	TokenHash string    `db:"token_hash"`
+	Username  string    `db:"username"`
+	CreatedAt time.Time `db:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
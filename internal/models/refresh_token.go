@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type RefreshToken struct {
+	TokenHash string     `db:"token_hash"`
+	Username  string     `db:"username"`
+	FamilyID  string     `db:"family_id"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Permissions checked by the service. They are also stored
+// in database, so roles can be composed without redeploy
+const (
+	PermUsersRead         = "users:read"
+	PermUsersList         = "users:list"
+	PermUsersSearch       = "users:search"
+	PermUsersWatch        = "users:watch"
+	PermUsersAuthenticate = "users:authenticate"
+	PermUsersUpdate       = "users:update"
+	PermUsersDelete       = "users:delete"
+	PermUsersRestore      = "users:restore"
+	PermUsersUnlock       = "users:unlock"
+	PermRolesManage       = "roles:manage"
+	PermAuditRead         = "audit:read"
+)
+
+// RoleUser is implicitly held by every authenticated user
+const RoleUser = "user"
+
+type UserRole struct {
+	Username  string    `db:"username"`
+	Role      string    `db:"role"`
+	GrantedAt time.Time `db:"granted_at"`
+}
+
+// Principal is who calls the service. Either
+// Username or Service is set, but never both
+type Principal struct {
+	// Username is set for users authenticated by access token
+	Username string
+	// Service is set for services authenticated by client certificate
+	Service string
+}
+
+func (p Principal) String() string {
+	if p.Service != "" {
+		return "service:" + p.Service
+	}
+	return "user:" + p.Username
+}
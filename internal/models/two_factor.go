@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type TOTP struct {
+	Username        string     `db:"username"`
+	SecretEncrypted []byte     `db:"secret_encrypted"`
+	ConfirmedAt     *time.Time `db:"confirmed_at"`
+	LastUsedStep    int64      `db:"last_used_step"`
+	CreatedAt       time.Time  `db:"created_at"`
+}
+
+func (t *TOTP) Enabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+type SecondFactorChallenge struct {
+	ChallengeHash string    `db:"challenge_hash"`
+	Username      string    `db:"username"`
+	IssueTokens   bool      `db:"issue_tokens"`
+	Attempts      int       `db:"attempts"`
+	CreatedAt     time.Time `db:"created_at"`
+	ExpiresAt     time.Time `db:"expires_at"`
+}
@@ -1,6 +1,9 @@
 package models
 
-import "github.com/go-playground/validator/v10"
+import (
+	"github.com/go-playground/validator/v10"
+	"time"
+)
 
 type UserCreate struct {
 	Username  string  `db:"username" validate:"required,min=2,max=40"`
@@ -12,13 +15,16 @@ type UserCreate struct {
 }
 
 type User struct {
-	Username     string  `db:"username" validate:"required,min=3,max=40"`
-	PasswordHash string  `db:"password_hash" validate:"required"`
-	Email        string  `db:"email" validate:"required,email,max=64"`
-	FirstName    string  `db:"first_name" validate:"omitempty,max=32"`
-	LastName     string  `db:"last_name" validate:"omitempty,max=32"`
-	AvatarID     *string `db:"avatar_id" validate:"omitempty,uuid"`
-	IsActive     bool    `db:"is_active" validate:""`
+	Username     string    `db:"username" validate:"required,min=3,max=40"`
+	PasswordHash string    `db:"password_hash" validate:"required"`
+	Email        string    `db:"email" validate:"required,email,max=64"`
+	FirstName    string    `db:"first_name" validate:"omitempty,max=32"`
+	LastName     string    `db:"last_name" validate:"omitempty,max=32"`
+	AvatarID     *string   `db:"avatar_id" validate:"omitempty,uuid"`
+	IsActive     bool      `db:"is_active" validate:""`
+	CreatedAt    time.Time `db:"created_at" validate:""`
+	// DeletedAt is selected only for deleted users
+	DeletedAt *time.Time `db:"deleted_at" validate:""`
 }
 
 type UpdateFields struct {
@@ -0,0 +1,26 @@
+package notifier
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+)
+
+// LogNotifier does not deliver anything, it only writes notifications
+// to the log. Intended for local development
+type LogNotifier struct {
+	logger *logrus.Logger
+}
+
+func NewLogNotifier(logger *logrus.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) Notify(_ context.Context, notification Notification) error {
+	n.logger.
+		WithField("kind", notification.Kind).
+		WithField("username", notification.Username).
+		WithField("email", notification.Email).
+		WithField("code", notification.Code).
+		Info("notification sent")
+	return nil
+}
@@ -0,0 +1,44 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryNotifier keeps all notifications in memory. Intended for tests
+type MemoryNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func NewMemoryNotifier() *MemoryNotifier {
+	return &MemoryNotifier{}
+}
+
+func (n *MemoryNotifier) Notify(_ context.Context, notification Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+// Sent returns copy of all notifications sent so far
+func (n *MemoryNotifier) Sent() []Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	sent := make([]Notification, len(n.sent))
+	copy(sent, n.sent)
+	return sent
+}
+
+// Last returns the latest notification of provided kind sent to user
+func (n *MemoryNotifier) Last(username string, kind Kind) (Notification, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := len(n.sent) - 1; i >= 0; i-- {
+		if n.sent[i].Username == username && n.sent[i].Kind == kind {
+			return n.sent[i], true
+		}
+	}
+	return Notification{}, false
+}
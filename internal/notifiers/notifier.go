@@ -0,0 +1,24 @@
+package notifier
+
+import "context"
+
+type Kind string
+
+const (
+	KindActivationCode Kind = "activation_code"
+	KindPasswordReset  Kind = "password_reset"
+	KindEmailChange    Kind = "email_change"
+)
+
+// Notification is a message addressed to a single user. Code holds
+// a secret (activation code, token, etc.) that user has to receive
+type Notification struct {
+	Kind     Kind
+	Username string
+	Email    string
+	Code     string
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
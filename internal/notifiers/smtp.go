@@ -0,0 +1,84 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"text/template"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+type message struct {
+	subject string
+	body    *template.Template
+}
+
+var messages = map[Kind]message{
+	KindActivationCode: {
+		subject: "Activate your account",
+		body: template.Must(template.New("activation").Parse(
+			"Hello, {{.Username}}!\r\n\r\nYour activation code is {{.Code}}\r\n",
+		)),
+	},
+	KindPasswordReset: {
+		subject: "Reset your password",
+		body: template.Must(template.New("password_reset").Parse(
+			"Hello, {{.Username}}!\r\n\r\nUse this token to reset your password: {{.Code}}\r\n" +
+				"If you did not request password reset, just ignore this email.\r\n",
+		)),
+	},
+	KindEmailChange: {
+		subject: "Confirm your new email",
+		body: template.Must(template.New("email_change").Parse(
+			"Hello, {{.Username}}!\r\n\r\nYour email confirmation code is {{.Code}}\r\n",
+		)),
+	},
+}
+
+type SMTPNotifier struct {
+	config SMTPConfig
+	auth   smtp.Auth
+}
+
+func NewSMTPNotifier(config SMTPConfig) *SMTPNotifier {
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	return &SMTPNotifier{config: config, auth: auth}
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, notification Notification) error {
+	msg, ok := messages[notification.Kind]
+	if !ok {
+		return fmt.Errorf("no email template for %s notification", notification.Kind)
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&body, "To: %s\r\n", notification.Email)
+	fmt.Fprintf(&body, "Subject: %s\r\n", msg.subject)
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	if err := msg.body.Execute(&body, notification); err != nil {
+		return err
+	}
+
+	// net/smtp does not support contexts, so only
+	// respect cancellation that happened before sending
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	address := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+	return smtp.SendMail(address, n.auth, n.config.From, []string{notification.Email}, body.Bytes())
+}
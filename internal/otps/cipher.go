@@ -0,0 +1,46 @@
+package otp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+var ErrMalformedCiphertext = errors.New("ciphertext is malformed")
+
+// SecretCipher encrypts TOTP secrets at rest with AES-256-GCM
+type SecretCipher struct {
+	aead cipher.AEAD
+}
+
+// NewSecretCipher expects 32 bytes long key
+func NewSecretCipher(key []byte) (*SecretCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretCipher{aead: aead}, nil
+}
+
+// Encrypt returns nonce followed by ciphertext
+func (c *SecretCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (c *SecretCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, ErrMalformedCiphertext
+	}
+	return c.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+}
@@ -0,0 +1,66 @@
+package otp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238, appendix B, truncated to 6 digits
+func TestCode_MatchesRFC6238Vectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, code := range vectors {
+		assert.Equal(t, code, Code(secret, Step(time.Unix(unix, 0))), "Wrong code at %d", unix)
+	}
+}
+
+func TestValidate_ToleratesSkew(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.Nil(t, err)
+	now := time.Now()
+
+	previous := Code(secret, Step(now)-1)
+	step, ok := Validate(secret, previous, now, 1)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now)-1, step)
+
+	old := Code(secret, Step(now)-3)
+	_, ok = Validate(secret, old, now, 1)
+	assert.False(t, ok, "Should reject code outside of skew")
+}
+
+func TestURI_ContainsEncodedSecret(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	uri := URI(secret, "user-service", "joe")
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/user-service:joe?"))
+	assert.Contains(t, uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	assert.Contains(t, uri, "issuer=user-service")
+}
+
+func TestSecretCipher_EncryptsAndDecrypts(t *testing.T) {
+	c, err := NewSecretCipher([]byte("0123456789abcdef0123456789abcdef"))
+	assert.Nil(t, err)
+
+	ciphertext, err := c.Encrypt([]byte("secret"))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(ciphertext), "secret")
+
+	plaintext, err := c.Decrypt(ciphertext)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = c.Decrypt(ciphertext)
+	assert.NotNil(t, err, "Should detect tampering")
+}
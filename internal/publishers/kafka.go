@@ -0,0 +1,49 @@
+package publisher
+
+import (
+	"context"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"github.com/segmentio/kafka-go"
+)
+
+type KafkaConfig struct {
+	Brokers []string
+	Topic   string
+}
+
+// KafkaPublisher uses username as message key, so all events
+// of one user go to the same partition and keep their order
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func NewKafkaPublisher(config KafkaConfig) *KafkaPublisher {
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Topic:        config.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, events []models.Event) error {
+	messages := make([]kafka.Message, len(events))
+	for i, event := range events {
+		messages[i] = kafka.Message{
+			Key:   []byte(event.Username),
+			Value: event.Payload,
+			Time:  event.CreatedAt,
+			Headers: []kafka.Header{
+				{Key: HeaderEventID, Value: []byte(eventID(event))},
+				{Key: HeaderEventType, Value: []byte(event.Type)},
+			},
+		}
+	}
+	return p.writer.WriteMessages(ctx, messages...)
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,33 @@
+package publisher
+
+import (
+	"context"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// LogPublisher does not deliver anything, it only writes events
+// to the log. Intended for local development
+type LogPublisher struct {
+	logger *logrus.Logger
+}
+
+func NewLogPublisher(logger *logrus.Logger) *LogPublisher {
+	return &LogPublisher{logger: logger}
+}
+
+func (p *LogPublisher) Publish(_ context.Context, events []models.Event) error {
+	for _, event := range events {
+		p.logger.
+			WithField("id", event.ID).
+			WithField("type", event.Type).
+			WithField("username", event.Username).
+			WithField("payload", string(event.Payload)).
+			Info("event published")
+	}
+	return nil
+}
+
+func (p *LogPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,50 @@
+package publisher
+
+import (
+	"context"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"sync"
+)
+
+// MemoryPublisher keeps all events in memory. Intended for tests
+type MemoryPublisher struct {
+	mu        sync.Mutex
+	published []models.Event
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, events []models.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published = append(p.published, events...)
+	return nil
+}
+
+func (p *MemoryPublisher) Close() error {
+	return nil
+}
+
+// Published returns copy of all events published so far
+func (p *MemoryPublisher) Published() []models.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	published := make([]models.Event, len(p.published))
+	copy(published, p.published)
+	return published
+}
+
+// ForUser returns events of one user in order they were published
+func (p *MemoryPublisher) ForUser(username string) []models.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var events []models.Event
+	for _, event := range p.published {
+		if event.Username == username {
+			events = append(events, event)
+		}
+	}
+	return events
+}
@@ -0,0 +1,59 @@
+package publisher
+
+import (
+	"context"
+	"github.com/nats-io/nats.go"
+	"github.com/practice-sem-2/user-service/internal/models"
+)
+
+type NATSConfig struct {
+	URL string
+	// SubjectPrefix is followed by event type, e.g. users.user.created
+	SubjectPrefix string
+}
+
+// NATSPublisher publishes to JetStream and waits for acknowledgement of
+// every event. Event id is used as message id, so stream drops duplicates
+type NATSPublisher struct {
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	prefix string
+}
+
+func NewNATSPublisher(config NATSConfig) (*NATSPublisher, error) {
+	conn, err := nats.Connect(config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &NATSPublisher{
+		conn:   conn,
+		js:     js,
+		prefix: config.SubjectPrefix,
+	}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, events []models.Event) error {
+	// Events are published one by one, so they are stored in order
+	for _, event := range events {
+		msg := nats.NewMsg(p.prefix + "." + event.Type)
+		msg.Data = event.Payload
+		msg.Header.Set(HeaderEventID, eventID(event))
+		msg.Header.Set(HeaderEventType, event.Type)
+
+		if _, err := p.js.PublishMsg(msg, nats.MsgId(eventID(event)), nats.Context(ctx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *NATSPublisher) Close() error {
+	return p.conn.Drain()
+}
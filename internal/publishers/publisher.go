@@ -0,0 +1,26 @@
+package publisher
+
+import (
+	"context"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"strconv"
+)
+
+// Publisher delivers events to other services. Events must be published in
+// the provided order, at least for the same user. If error is returned, all
+// events will be published again, so consumers must tolerate duplicates
+type Publisher interface {
+	Publish(ctx context.Context, events []models.Event) error
+	Close() error
+}
+
+// Headers attached to every published message,
+// so consumers can deduplicate and route events without parsing them
+const (
+	HeaderEventID   = "event-id"
+	HeaderEventType = "event-type"
+)
+
+func eventID(event models.Event) string {
+	return strconv.FormatInt(event.ID, 10)
+}
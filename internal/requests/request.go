@@ -0,0 +1,33 @@
+package request
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+)
+
+type idKey struct{}
+
+type loggerKey struct{}
+
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// ID returns ID of the request or empty string outside of requests
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+func WithLogger(ctx context.Context, logger *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger returns logger with fields of the request, or
+// standard logger outside of requests, so it is never nil
+func Logger(ctx context.Context) *logrus.Entry {
+	if logger, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return logger
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
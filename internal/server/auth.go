@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"github.com/practice-sem-2/user-service/internal/models"
+	request "github.com/practice-sem-2/user-service/internal/requests"
+	usecase "github.com/practice-sem-2/user-service/internal/usecases"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"strings"
+)
+
+// Rule tells who may call a method
+type Rule struct {
+	// Public methods can be called by anyone
+	Public bool
+	// Self lets users call method for themselves without permission.
+	// Target user is taken from username field of request
+	Self bool
+	// Permission is required to call method for other users
+	Permission string
+}
+
+// methodRules must contain every method, the ones missing are denied
+var methodRules = map[string]Rule{
+	"/users.User/CreateUser":           {Public: true},
+	"/users.User/GetUser":              {Self: true, Permission: models.PermUsersRead},
+	"/users.User/GetManyUsers":         {Permission: models.PermUsersRead},
+	"/users.User/ListUsers":            {Permission: models.PermUsersList},
+	"/users.User/SearchUsers":          {Permission: models.PermUsersSearch},
+	"/users.User/WatchUsers":           {Permission: models.PermUsersWatch},
+	"/users.User/ActivateUser":         {Public: true},
+	"/users.User/UpdateUser":           {Self: true, Permission: models.PermUsersUpdate},
+	"/users.User/DeleteUser":           {Self: true, Permission: models.PermUsersDelete},
+	"/users.User/RestoreUser":          {Permission: models.PermUsersRestore},
+	"/users.User/GetUserByCredentials": {Permission: models.PermUsersAuthenticate},
+	"/users.User/ResendActivationCode": {Public: true},
+	"/users.User/RequestPasswordReset": {Public: true},
+	"/users.User/ConfirmPasswordReset": {Public: true},
+	"/users.User/ChangePassword":       {Self: true},
+	"/users.User/ChangeEmail":          {Self: true},
+	"/users.User/ConfirmEmailChange":   {Public: true},
+	"/users.User/Login":                {Public: true},
+	"/users.User/Refresh":              {Public: true},
+	"/users.User/Logout":               {Public: true},
+	"/users.User/GetSigningKeys":       {Public: true},
+	"/users.User/UnlockUser":           {Permission: models.PermUsersUnlock},
+	"/users.User/EnrollTOTP":           {Self: true},
+	"/users.User/ConfirmTOTP":          {Self: true},
+	"/users.User/DisableTOTP":          {Self: true},
+	"/users.User/VerifySecondFactor":   {Public: true},
+	"/users.User/GetUserRoles":         {Self: true, Permission: models.PermUsersRead},
+	"/users.User/AssignRole":           {Permission: models.PermRolesManage},
+	"/users.User/RevokeRole":           {Permission: models.PermRolesManage},
+	"/users.User/ListAuditEvents":      {Permission: models.PermAuditRead},
+
+	"/grpc.health.v1.Health/Check": {Public: true},
+	"/grpc.health.v1.Health/Watch": {Public: true},
+	// Reflection is registered only if enabled in config
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": {Public: true},
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":      {Public: true},
+}
+
+var (
+	ErrUnauthenticated  = errorWithReason(codes.Unauthenticated, "valid access token or client certificate is required", "UNAUTHENTICATED")
+	ErrInvalidToken     = errorWithReason(codes.Unauthenticated, "access token is invalid or expired", "ACCESS_TOKEN_INVALID")
+	ErrPermissionDenied = errorWithReason(codes.PermissionDenied, "caller is not allowed to call this method", "PERMISSION_DENIED")
+)
+
+type TokenVerifier interface {
+	Verify(token string) (string, error)
+}
+
+// AuthInterceptor authenticates callers and checks them against methodRules
+type AuthInterceptor struct {
+	verifier TokenVerifier
+	access   *usecase.AccessUseCase
+	config   Config
+}
+
+func NewAuthInterceptor(verifier TokenVerifier, access *usecase.AccessUseCase, config Config) *AuthInterceptor {
+	return &AuthInterceptor{
+		verifier: verifier,
+		access:   access,
+		config:   config,
+	}
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns authenticated caller, if there is one
+func PrincipalFromContext(ctx context.Context) (models.Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(models.Principal)
+	return p, ok
+}
+
+func (a *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authorize(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream does not see requests, so Self rules never apply to streams
+func (a *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authorize(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// contextStream replaces context of the stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize returns context with principal if caller may call method
+func (a *AuthInterceptor) authorize(ctx context.Context, method string, req interface{}) (context.Context, error) {
+	rule, ok := methodRules[method]
+	if !ok {
+		return nil, ErrPermissionDenied
+	}
+
+	principal, authenticated, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if authenticated {
+		ctx = context.WithValue(ctx, principalKey{}, principal)
+	}
+	ctx = usecase.WithRequestInfo(ctx, a.requestInfo(ctx, principal, authenticated))
+
+	if rule.Public {
+		return ctx, nil
+	}
+	if !authenticated {
+		return nil, ErrUnauthenticated
+	}
+
+	if rule.Self && principal.Username != "" {
+		if target, ok := req.(interface{ GetUsername() string }); ok && target.GetUsername() == principal.Username {
+			return ctx, nil
+		}
+	}
+
+	if rule.Permission == "" {
+		return nil, ErrPermissionDenied
+	}
+
+	permissions, err := a.access.Permissions(ctx, principal)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if _, ok := permissions[rule.Permission]; !ok {
+		return nil, ErrPermissionDenied
+	}
+	return ctx, nil
+}
+
+func (a *AuthInterceptor) requestInfo(ctx context.Context, principal models.Principal, authenticated bool) usecase.RequestInfo {
+	info := usecase.RequestInfo{
+		RequestID:   request.ID(ctx),
+		PeerAddress: ClientIP(ctx, a.config),
+	}
+	if authenticated {
+		info.Actor = principal.String()
+	}
+	return info
+}
+
+// authenticate prefers access token, so services can call on behalf of users
+func (a *AuthInterceptor) authenticate(ctx context.Context) (models.Principal, bool, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md.Get("authorization"); len(values) > 0 {
+		scheme, token, found := strings.Cut(values[0], " ")
+		if !found || !strings.EqualFold(scheme, "bearer") {
+			return models.Principal{}, false, ErrInvalidToken
+		}
+
+		username, err := a.verifier.Verify(strings.TrimSpace(token))
+		if err != nil {
+			return models.Principal{}, false, ErrInvalidToken
+		}
+		return models.Principal{Username: username}, true, nil
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			if identity := certificateIdentity(info.State.VerifiedChains[0][0]); identity != "" {
+				return models.Principal{Service: identity}, true, nil
+			}
+		}
+	}
+
+	// Set by service mesh proxy, which terminates mTLS
+	if values := md.Get("x-forwarded-client-cert"); a.config.TrustForwardedClientCert && len(values) > 0 {
+		if identity := forwardedCertificateIdentity(values[len(values)-1]); identity != "" {
+			return models.Principal{Service: identity}, true, nil
+		}
+	}
+
+	return models.Principal{}, false, nil
+}
+
+// certificateIdentity prefers URI SAN, which is used by SPIFFE,
+// and falls back to common name
+func certificateIdentity(cert *x509.Certificate) string {
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return cert.Subject.CommonName
+}
+
+// forwardedCertificateIdentity parses x-forwarded-client-cert header the same
+// way as certificateIdentity. Only the last element is used, because it is
+// added by the proxy next to the service and the rest could be forged
+func forwardedCertificateIdentity(header string) string {
+	elements := splitUnquoted(header, ',')
+	element := elements[len(elements)-1]
+
+	var uri, subject string
+	for _, pair := range splitUnquoted(element, ';') {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(key) {
+		case "uri":
+			uri = value
+		case "subject":
+			subject = value
+		}
+	}
+
+	if uri != "" {
+		return uri
+	}
+	for _, attribute := range strings.Split(subject, ",") {
+		if attribute = strings.TrimSpace(attribute); strings.HasPrefix(attribute, "CN=") {
+			return strings.TrimPrefix(attribute, "CN=")
+		}
+	}
+	return ""
+}
+
+// splitUnquoted splits s by separators which are not inside double quotes
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
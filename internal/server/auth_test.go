@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"github.com/practice-sem-2/user-service/internal/pb"
+	request "github.com/practice-sem-2/user-service/internal/requests"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+type fakeVerifier map[string]string
+
+func (v fakeVerifier) Verify(token string) (string, error) {
+	if username, ok := v[token]; ok {
+		return username, nil
+	}
+	return "", errors.New("invalid token")
+}
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestMethodRules_CoverEveryMethod(t *testing.T) {
+	service := pb.User_ServiceDesc.ServiceName
+	for _, method := range pb.User_ServiceDesc.Methods {
+		assert.Contains(t, methodRules, "/"+service+"/"+method.MethodName)
+	}
+	for _, stream := range pb.User_ServiceDesc.Streams {
+		assert.Contains(t, methodRules, "/"+service+"/"+stream.StreamName)
+	}
+}
+
+func TestAuthorize_PublicMethodNeedsNoCredentials(t *testing.T) {
+	a := NewAuthInterceptor(fakeVerifier{}, nil, Config{})
+
+	_, err := a.authorize(context.Background(), "/users.User/Login", &pb.LoginRequest{})
+	assert.NoError(t, err)
+}
+
+func TestAuthorize_UnknownMethodIsDenied(t *testing.T) {
+	a := NewAuthInterceptor(fakeVerifier{"t": "joe"}, nil, Config{})
+
+	_, err := a.authorize(withToken("t"), "/users.User/Unknown", nil)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAuthorize_UserCanModifyThemselves(t *testing.T) {
+	a := NewAuthInterceptor(fakeVerifier{"t": "joe"}, nil, Config{})
+
+	ctx, err := a.authorize(withToken("t"), "/users.User/DeleteUser", &pb.DeleteUserRequest{Username: "joe"})
+	if assert.NoError(t, err) {
+		principal, ok := PrincipalFromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, models.Principal{Username: "joe"}, principal)
+	}
+
+	_, err = a.authorize(withToken("t"), "/users.User/ChangePassword", &pb.ChangePasswordRequest{Username: "ann"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "self only method for someone else")
+}
+
+func TestAuthorize_RejectsMissingOrInvalidToken(t *testing.T) {
+	a := NewAuthInterceptor(fakeVerifier{"t": "joe"}, nil, Config{})
+
+	_, err := a.authorize(context.Background(), "/users.User/DeleteUser", &pb.DeleteUserRequest{Username: "joe"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = a.authorize(withToken("forged"), "/users.User/Login", &pb.LoginRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "invalid token is rejected even for public methods")
+}
+
+func TestForwardedCertificateIdentity(t *testing.T) {
+	assert.Equal(t, "spiffe://cluster/ns/default/sa/chat", forwardedCertificateIdentity(
+		`By=spiffe://cluster/ns/default/sa/users;Hash=abc;URI=spiffe://cluster/ns/default/sa/chat`,
+	))
+	assert.Equal(t, "chat", forwardedCertificateIdentity(
+		`Hash=abc;Subject="CN=forged,O=x",Hash=def;Subject="CN=chat,O=Example, Inc"`,
+	))
+	assert.Equal(t, "", forwardedCertificateIdentity(`Hash=abc`))
+}
+
+func TestRequestInfo(t *testing.T) {
+	a := NewAuthInterceptor(fakeVerifier{}, nil, Config{})
+	ctx := request.WithID(context.Background(), "42")
+
+	info := a.requestInfo(ctx, models.Principal{Service: "billing"}, true)
+	assert.Equal(t, "service:billing", info.Actor)
+	assert.Equal(t, "42", info.RequestID)
+
+	info = a.requestInfo(context.Background(), models.Principal{}, false)
+	assert.Empty(t, info.Actor)
+	assert.Empty(t, info.RequestID)
+}
+
+func TestAuthorize_HealthCheckIsPublic(t *testing.T) {
+	a := NewAuthInterceptor(fakeVerifier{}, nil, Config{})
+
+	_, err := a.authorize(context.Background(), "/grpc.health.v1.Health/Check", nil)
+	assert.NoError(t, err)
+}
+
+func TestAuthenticate_TrustsForwardedCertificateOnlyWhenEnabled(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-forwarded-client-cert", "URI=spiffe://cluster/sa/chat"))
+
+	a := NewAuthInterceptor(fakeVerifier{}, nil, Config{TrustProxyHeaders: true})
+	_, authenticated, err := a.authenticate(ctx)
+	assert.NoError(t, err)
+	assert.False(t, authenticated, "trusting client address must not trust certificate")
+
+	a = NewAuthInterceptor(fakeVerifier{}, nil, Config{TrustForwardedClientCert: true})
+	principal, authenticated, err := a.authenticate(ctx)
+	assert.NoError(t, err)
+	assert.True(t, authenticated)
+	assert.Equal(t, models.Principal{Service: "spiffe://cluster/sa/chat"}, principal)
+}
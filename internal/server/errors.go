@@ -0,0 +1,297 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	request "github.com/practice-sem-2/user-service/internal/requests"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	usecase "github.com/practice-sem-2/user-service/internal/usecases"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var (
+	ErrUserNotFound          = errorWithReason(codes.NotFound, "user with provided username does not exist", "USER_NOT_FOUND")
+	ErrUserAlreadyExists     = errorWithReason(codes.AlreadyExists, "user already exists", "USERNAME_TAKEN")
+	ErrEmailAlreadyExists    = errorWithReason(codes.AlreadyExists, "provided email is already taken", "EMAIL_TAKEN")
+	ErrInvalidActivationCode = errorWithReason(codes.InvalidArgument, "provided activation code is invalid", "ACTIVATION_CODE_INVALID")
+	ErrUserAlreadyActive     = errorWithReason(codes.FailedPrecondition, "user is already active", "USER_ALREADY_ACTIVE")
+	ErrResendCooldown        = errorWithReason(codes.ResourceExhausted, "activation code has been sent recently, try again later", "RESEND_COOLDOWN")
+	ErrActivationExpired     = errorWithReason(codes.FailedPrecondition, "activation code has expired, request a new one", "ACTIVATION_CODE_EXPIRED")
+	ErrActivationExhausted   = errorWithReason(codes.FailedPrecondition, "activation code is locked after too many attempts, request a new one", "ACTIVATION_CODE_EXHAUSTED")
+	ErrInvalidResetToken     = errorWithReason(codes.InvalidArgument, "password reset token is invalid or expired", "RESET_TOKEN_INVALID")
+	ErrWrongPassword         = errorWithReason(codes.PermissionDenied, "provided password is incorrect", "WRONG_PASSWORD")
+	ErrNoPendingEmailChange  = errorWithReason(codes.FailedPrecondition, "there is no pending email change", "NO_PENDING_EMAIL_CHANGE")
+	ErrInvalidEmailCode      = errorWithReason(codes.InvalidArgument, "provided email confirmation code is invalid", "EMAIL_CODE_INVALID")
+	ErrEmailChangeExpired    = errorWithReason(codes.FailedPrecondition, "email confirmation code has expired, change email again", "EMAIL_CODE_EXPIRED")
+	ErrEmailChangeExhausted  = errorWithReason(codes.FailedPrecondition, "email confirmation code is locked after too many attempts, change email again", "EMAIL_CODE_EXHAUSTED")
+	ErrInvalidCredentials    = errorWithReason(codes.Unauthenticated, "username or password is incorrect", "INVALID_CREDENTIALS")
+	ErrUserNotActive         = errorWithReason(codes.FailedPrecondition, "user is not activated", "USER_NOT_ACTIVE")
+	ErrInvalidRefreshToken   = errorWithReason(codes.Unauthenticated, "refresh token is invalid or expired", "REFRESH_TOKEN_INVALID")
+	ErrRefreshTokenReused    = errorWithReason(codes.Unauthenticated, "refresh token has already been used, all sessions started from it are revoked", "REFRESH_TOKEN_REUSED")
+	ErrTwoFactorUnavailable  = errorWithReason(codes.Unimplemented, "two-factor authentication is not configured", "TWO_FACTOR_UNAVAILABLE")
+	ErrTwoFactorEnabled      = errorWithReason(codes.FailedPrecondition, "two-factor authentication is already enabled", "TWO_FACTOR_ENABLED")
+	ErrTwoFactorNotEnrolled  = errorWithReason(codes.FailedPrecondition, "two-factor authentication is not enabled", "TWO_FACTOR_NOT_ENABLED")
+	ErrInvalidSecondFactor   = errorWithReason(codes.InvalidArgument, "provided second factor code is invalid", "SECOND_FACTOR_INVALID")
+	ErrInvalidChallenge      = errorWithReason(codes.Unauthenticated, "second factor challenge is invalid or expired, log in again", "CHALLENGE_INVALID")
+	ErrChallengeExhausted    = errorWithReason(codes.Unauthenticated, "second factor challenge is locked after too many attempts, log in again", "CHALLENGE_EXHAUSTED")
+	ErrInvalidPageToken      = errorWithReason(codes.InvalidArgument, "page token is invalid or does not match the request", "PAGE_TOKEN_INVALID")
+	ErrEmptySearchQuery      = invalidArgument(fieldViolation("query", "must not be empty"))
+	ErrResumeExpired         = errorWithReason(codes.OutOfRange, "changes after requested sequence number are no longer kept, reload users and watch from now", "RESUME_EXPIRED")
+	ErrWatcherTooSlow        = errorWithReason(codes.ResourceExhausted, "watcher does not keep up with changes, resume from the last received one", "WATCHER_TOO_SLOW")
+	ErrFeedClosed            = errorWithReason(codes.Unavailable, "server is shutting down, resume from the last received change", "SHUTTING_DOWN")
+	ErrRestoreExpired        = errorWithReason(codes.FailedPrecondition, "user was deleted too long ago and can't be restored", "RESTORE_EXPIRED")
+	ErrRoleNotFound          = errorWithReason(codes.NotFound, "role does not exist", "ROLE_NOT_FOUND")
+	ErrRoleNotAssigned       = errorWithReason(codes.NotFound, "user does not have the role", "ROLE_NOT_ASSIGNED")
+	ErrRoleAlreadyGiven      = errorWithReason(codes.AlreadyExists, "user already has the role", "ROLE_ALREADY_ASSIGNED")
+	ErrPasswordNotUpdatable  = invalidArgument(fieldViolation("password", "can't be updated, use ChangePassword"))
+)
+
+const errorDomain = "user-service"
+
+// errorWithReason attaches machine-readable reason to the status,
+// so clients can tell apart errors that share the same code
+func errorWithReason(code codes.Code, msg string, reason string) error {
+	st, err := status.New(code, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: errorDomain,
+	})
+	if err != nil {
+		return status.Error(code, msg)
+	}
+	return st.Err()
+}
+
+func wrapError(err error) error {
+	errorMapper := []struct {
+		from error
+		to   error
+	}{
+		{from: storage.ErrUserNotFound, to: ErrUserNotFound},
+		{from: storage.ErrUserAlreadyExists, to: ErrUserAlreadyExists},
+		{from: storage.ErrEmailAlreadyExists, to: ErrEmailAlreadyExists},
+		{from: storage.ErrInvalidCode, to: ErrInvalidActivationCode},
+		{from: usecase.ErrUserAlreadyActive, to: ErrUserAlreadyActive},
+		{from: usecase.ErrResendCooldown, to: ErrResendCooldown},
+		{from: usecase.ErrActivationCodeExpired, to: ErrActivationExpired},
+		{from: usecase.ErrActivationCodeExhausted, to: ErrActivationExhausted},
+		{from: usecase.ErrInvalidResetToken, to: ErrInvalidResetToken},
+		{from: usecase.ErrWrongPassword, to: ErrWrongPassword},
+		{from: storage.ErrNoPendingEmailChange, to: ErrNoPendingEmailChange},
+		{from: usecase.ErrInvalidEmailCode, to: ErrInvalidEmailCode},
+		{from: usecase.ErrEmailChangeExpired, to: ErrEmailChangeExpired},
+		{from: usecase.ErrEmailChangeExhausted, to: ErrEmailChangeExhausted},
+		{from: usecase.ErrInvalidCredentials, to: ErrInvalidCredentials},
+		{from: usecase.ErrUserNotActive, to: ErrUserNotActive},
+		{from: usecase.ErrInvalidRefreshToken, to: ErrInvalidRefreshToken},
+		{from: usecase.ErrRefreshTokenReused, to: ErrRefreshTokenReused},
+		{from: usecase.ErrTwoFactorUnavailable, to: ErrTwoFactorUnavailable},
+		{from: usecase.ErrTwoFactorAlreadyEnabled, to: ErrTwoFactorEnabled},
+		{from: usecase.ErrTwoFactorNotEnrolled, to: ErrTwoFactorNotEnrolled},
+		{from: usecase.ErrInvalidSecondFactor, to: ErrInvalidSecondFactor},
+		{from: usecase.ErrInvalidChallenge, to: ErrInvalidChallenge},
+		{from: usecase.ErrChallengeExhausted, to: ErrChallengeExhausted},
+		{from: usecase.ErrInvalidPageToken, to: ErrInvalidPageToken},
+		{from: storage.ErrInvalidCursor, to: ErrInvalidPageToken},
+		{from: usecase.ErrEmptySearchQuery, to: ErrEmptySearchQuery},
+		{from: usecase.ErrResumeExpired, to: ErrResumeExpired},
+		{from: usecase.ErrWatcherTooSlow, to: ErrWatcherTooSlow},
+		{from: usecase.ErrFeedClosed, to: ErrFeedClosed},
+		{from: usecase.ErrRestoreExpired, to: ErrRestoreExpired},
+		{from: storage.ErrRoleNotFound, to: ErrRoleNotFound},
+		{from: storage.ErrRoleNotAssigned, to: ErrRoleNotAssigned},
+		{from: storage.ErrRoleAlreadyGiven, to: ErrRoleAlreadyGiven},
+		{from: usecase.ErrPasswordNotUpdatable, to: ErrPasswordNotUpdatable},
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	var tooMany *usecase.TooManyAttemptsError
+	if errors.As(err, &tooMany) {
+		return tooManyAttemptsError(tooMany)
+	}
+
+	var unknownColumn *storage.UnknownColumnError
+	if errors.As(err, &unknownColumn) {
+		return invalidArgument(fieldViolation("field_mask", unknownColumn.Error()))
+	}
+
+	var unsortableColumn *storage.UnsortableColumnError
+	if errors.As(err, &unsortableColumn) {
+		return invalidArgument(fieldViolation("order_by", unsortableColumn.Error()))
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+
+	for _, mapping := range errorMapper {
+		if errors.Is(err, mapping.from) {
+			return mapping.to
+		}
+	}
+	// Cause is logged by ErrorInterceptor, callers only get request ID
+	return &internalError{cause: err}
+}
+
+func tooManyAttemptsError(e *usecase.TooManyAttemptsError) error {
+	reason := "LOGIN_THROTTLED"
+	if e.Locked {
+		reason = "ACCOUNT_LOCKED"
+	}
+
+	st, err := status.New(codes.ResourceExhausted, e.Error()).WithDetails(
+		&errdetails.ErrorInfo{Reason: reason, Domain: errorDomain},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)},
+	)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, e.Error())
+	}
+	return st.Err()
+}
+
+// invalidArgument describes which request fields are invalid and why
+func invalidArgument(violations ...*errdetails.BadRequest_FieldViolation) error {
+	msg := "request is invalid"
+	if len(violations) == 1 {
+		msg = fmt.Sprintf("%s %s", violations[0].Field, violations[0].Description)
+	}
+
+	st, err := status.New(codes.InvalidArgument, msg).WithDetails(
+		&errdetails.BadRequest{FieldViolations: violations},
+		&errdetails.ErrorInfo{Reason: "INVALID_ARGUMENT", Domain: errorDomain},
+	)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return st.Err()
+}
+
+func fieldViolation(field string, description string) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{Field: field, Description: description}
+}
+
+// requestFields are request field names of validated model fields
+var requestFields = map[string]string{
+	"Username":  "username",
+	"Password":  "password",
+	"Email":     "email",
+	"FirstName": "first_name",
+	"LastName":  "last_name",
+	"AvatarID":  "avatar_id",
+}
+
+// validationError turns validator errors into field violations. Fields
+// are named as in requests, renamed takes precedence over requestFields
+func validationError(err error, renamed map[string]string) error {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return wrapError(err)
+	}
+
+	violations := make([]*errdetails.BadRequest_FieldViolation, len(errs))
+	for i, e := range errs {
+		field, ok := renamed[e.Field()]
+		if !ok {
+			field, ok = requestFields[e.Field()]
+		}
+		if !ok {
+			field = e.Field()
+		}
+		violations[i] = fieldViolation(field, describeViolation(e))
+	}
+	return invalidArgument(violations...)
+}
+
+func describeViolation(e validator.FieldError) string {
+	switch e.Tag() {
+	case "required":
+		return "must not be empty"
+	case "min":
+		return fmt.Sprintf("must be at least %s characters long", e.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s characters long", e.Param())
+	case "email":
+		return "must be a valid email address"
+	case "uuid":
+		return "must be a valid UUID"
+	default:
+		return fmt.Sprintf("does not satisfy %s", e.Tag())
+	}
+}
+
+// internalError hides the cause from callers even if it is
+// not replaced by ErrorInterceptor, which logs the cause
+type internalError struct {
+	cause error
+}
+
+func (e *internalError) Error() string {
+	return e.cause.Error()
+}
+
+func (e *internalError) Unwrap() error {
+	return e.cause
+}
+
+func (e *internalError) GRPCStatus() *status.Status {
+	return status.New(codes.Internal, "internal error")
+}
+
+// ErrorInterceptor logs unexpected errors with the request logger and
+// replaces them with ID of the request, so they can be found in logs
+type ErrorInterceptor struct{}
+
+func NewErrorInterceptor() *ErrorInterceptor {
+	return &ErrorInterceptor{}
+}
+
+func (i *ErrorInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, i.handle(ctx, info.FullMethod, err)
+		}
+		return resp, nil
+	}
+}
+
+func (i *ErrorInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := handler(srv, ss); err != nil {
+			return i.handle(ss.Context(), info.FullMethod, err)
+		}
+		return nil
+	}
+}
+
+// handle passes through errors meant for callers, which already have status
+func (i *ErrorInterceptor) handle(ctx context.Context, method string, err error) error {
+	var internal *internalError
+	if _, ok := status.FromError(err); ok && !errors.As(err, &internal) {
+		return err
+	}
+
+	request.Logger(ctx).
+		WithField("method", method).
+		WithError(err).
+		Error("internal error")
+
+	id := request.ID(ctx)
+	msg := fmt.Sprintf("internal error, request id %s", id)
+	st, detailsErr := status.New(codes.Internal, msg).WithDetails(
+		&errdetails.ErrorInfo{Reason: "INTERNAL", Domain: errorDomain},
+		&errdetails.RequestInfo{RequestId: id},
+	)
+	if detailsErr != nil {
+		return status.Error(codes.Internal, msg)
+	}
+	return st.Err()
+}
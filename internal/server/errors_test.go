@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"github.com/practice-sem-2/user-service/internal/pb"
+	request "github.com/practice-sem-2/user-service/internal/requests"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+)
+
+func errorDetails(err error) (*errdetails.ErrorInfo, *errdetails.BadRequest) {
+	var info *errdetails.ErrorInfo
+	var badRequest *errdetails.BadRequest
+	for _, detail := range status.Convert(err).Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.BadRequest:
+			badRequest = d
+		}
+	}
+	return info, badRequest
+}
+
+func TestParseCreateRequest_ReturnsFieldViolations(t *testing.T) {
+	_, err := ParseCreateRequest(&pb.CreateUserRequest{Username: "j", Password: "secret", Email: "joe@example.com"})
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	info, badRequest := errorDetails(err)
+	if assert.NotNil(t, info) {
+		assert.Equal(t, "INVALID_ARGUMENT", info.Reason)
+	}
+	if assert.NotNil(t, badRequest) && assert.Len(t, badRequest.FieldViolations, 1) {
+		assert.Equal(t, "username", badRequest.FieldViolations[0].Field)
+		assert.Equal(t, "must be at least 2 characters long", badRequest.FieldViolations[0].Description)
+	}
+}
+
+func TestValidatePassword_UsesRequestFieldName(t *testing.T) {
+	err := ValidatePassword("new_password", "123")
+
+	_, badRequest := errorDetails(err)
+	if assert.NotNil(t, badRequest) && assert.Len(t, badRequest.FieldViolations, 1) {
+		assert.Equal(t, "new_password", badRequest.FieldViolations[0].Field)
+	}
+}
+
+func TestWrapError_DomainErrorHasReason(t *testing.T) {
+	err := wrapError(storage.ErrEmailAlreadyExists)
+
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	info, _ := errorDetails(err)
+	if assert.NotNil(t, info) {
+		assert.Equal(t, "EMAIL_TAKEN", info.Reason)
+		assert.Equal(t, errorDomain, info.Domain)
+	}
+}
+
+func TestWrapError_ColumnErrorsPointToTheirField(t *testing.T) {
+	_, badRequest := errorDetails(wrapError(&storage.UnknownColumnError{Column: "password_hash"}))
+	if assert.NotNil(t, badRequest) && assert.Len(t, badRequest.FieldViolations, 1) {
+		assert.Equal(t, "field_mask", badRequest.FieldViolations[0].Field)
+	}
+
+	_, badRequest = errorDetails(wrapError(&storage.UnsortableColumnError{Column: "avatar_id"}))
+	if assert.NotNil(t, badRequest) && assert.Len(t, badRequest.FieldViolations, 1) {
+		assert.Equal(t, "order_by", badRequest.FieldViolations[0].Field)
+	}
+}
+
+func TestWrapError_HidesInternalError(t *testing.T) {
+	err := wrapError(errors.New("pq: relation \"users\" does not exist"))
+
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.NotContains(t, status.Convert(err).Message(), "relation")
+}
+
+func TestErrorInterceptor_LogsInternalErrorWithRequestID(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	interceptor := NewErrorInterceptor().Unary()
+	info := &grpc.UnaryServerInfo{FullMethod: "/users.User/GetUser"}
+	ctx := request.WithID(context.Background(), "abc-123")
+	ctx = request.WithLogger(ctx, logger.WithField("request_id", "abc-123"))
+
+	_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, wrapError(errors.New("connection refused"))
+	})
+
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.NotContains(t, status.Convert(err).Message(), "connection refused")
+	if assert.Len(t, hook.Entries, 1) {
+		entry := hook.LastEntry()
+		assert.Equal(t, logrus.ErrorLevel, entry.Level)
+		assert.Equal(t, "abc-123", entry.Data["request_id"], "Should keep fields of the request")
+		assert.Equal(t, "/users.User/GetUser", entry.Data["method"])
+		assert.Contains(t, status.Convert(err).Message(), "abc-123")
+	}
+}
+
+func TestErrorInterceptor_PassesStatusErrors(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	interceptor := NewErrorInterceptor().Unary()
+	info := &grpc.UnaryServerInfo{FullMethod: "/users.User/GetUser"}
+	ctx := request.WithLogger(context.Background(), logrus.NewEntry(logger))
+
+	_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, wrapError(storage.ErrUserNotFound)
+	})
+
+	assert.Equal(t, ErrUserNotFound, err)
+	assert.Empty(t, hook.Entries)
+}
+
+func TestValidationError_UpdateFields(t *testing.T) {
+	name := strings.Repeat("a", 40)
+	err := validationError(models.Validate.Struct(models.UpdateFields{FirstName: &name}), nil)
+
+	_, badRequest := errorDetails(err)
+	if assert.NotNil(t, badRequest) && assert.Len(t, badRequest.FieldViolations, 1) {
+		assert.Equal(t, "first_name", badRequest.FieldViolations[0].Field)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"github.com/practice-sem-2/user-service/internal/pb"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"sync"
+)
+
+// Health reports readiness both over grpc.health.v1 and over HTTP.
+// Service is not ready until the first successful check
+type Health struct {
+	server       *health.Server
+	mu           sync.Mutex
+	ready        bool
+	shuttingDown bool
+}
+
+func NewHealth() *Health {
+	h := &Health{server: health.NewServer()}
+	h.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	return h
+}
+
+// Server must be registered in grpc server
+func (h *Health) Server() healthpb.HealthServer {
+	return h.server
+}
+
+func (h *Health) SetReady(ready bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shuttingDown || h.ready == ready {
+		return
+	}
+	h.ready = ready
+	if ready {
+		h.setStatus(healthpb.HealthCheckResponse_SERVING)
+	} else {
+		h.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// Shutdown marks service as not ready for good, so that
+// load balancers stop sending requests before it stops
+func (h *Health) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.shuttingDown = true
+	h.ready = false
+	h.server.Shutdown()
+}
+
+func (h *Health) Ready() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ready
+}
+
+// setStatus sets status of the whole server and of every service
+func (h *Health) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	h.server.SetServingStatus("", status)
+	h.server.SetServingStatus(pb.User_ServiceDesc.ServiceName, status)
+}
+
+// LivezHandler responds OK as long as process serves HTTP
+func (h *Health) LivezHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// ReadyzHandler responds 503 until service is ready and once it shuts down
+func (h *Health) ReadyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"github.com/practice-sem-2/user-service/internal/pb"
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func servingStatus(t *testing.T, h *Health, service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := h.Server().Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	assert.NoError(t, err)
+	return resp.GetStatus()
+}
+
+func readyzCode(h *Health) int {
+	rec := httptest.NewRecorder()
+	h.ReadyzHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return rec.Code
+}
+
+func TestHealth_FollowsReadiness(t *testing.T) {
+	h := NewHealth()
+	service := pb.User_ServiceDesc.ServiceName
+
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, h, service), "not ready before first check")
+	assert.Equal(t, http.StatusServiceUnavailable, readyzCode(h))
+
+	h.SetReady(true)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, h, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, h, service))
+	assert.Equal(t, http.StatusOK, readyzCode(h))
+
+	h.SetReady(false)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, h, service))
+	assert.Equal(t, http.StatusServiceUnavailable, readyzCode(h))
+}
+
+func TestHealth_ShutdownIsFinal(t *testing.T) {
+	h := NewHealth()
+	h.SetReady(true)
+
+	h.Shutdown()
+	h.SetReady(true)
+
+	assert.False(t, h.Ready())
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, h, pb.User_ServiceDesc.ServiceName))
+	assert.Equal(t, http.StatusServiceUnavailable, readyzCode(h))
+
+	rec := httptest.NewRecorder()
+	h.LivezHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, rec.Code, "process is still alive while shutting down")
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	request "github.com/practice-sem-2/user-service/internal/requests"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"time"
+	"unicode"
+)
+
+// requestIDHeader is both accepted from callers and sent back to them
+const requestIDHeader = "x-request-id"
+
+// maxRequestIDLength is the longest request ID accepted from callers
+const maxRequestIDLength = 128
+
+// LoggingInterceptor assigns ID to every request, puts logger with fields of
+// the request into its context and logs the request once it is finished
+type LoggingInterceptor struct {
+	logger *logrus.Logger
+	config Config
+}
+
+func NewLoggingInterceptor(logger *logrus.Logger, config Config) *LoggingInterceptor {
+	return &LoggingInterceptor{
+		logger: logger,
+		config: config,
+	}
+}
+
+func (i *LoggingInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, id := i.start(ctx, info.FullMethod)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+
+		started := time.Now()
+		resp, err := handler(ctx, req)
+		i.finish(ctx, started, err)
+		return resp, err
+	}
+}
+
+func (i *LoggingInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, id := i.start(ss.Context(), info.FullMethod)
+		_ = ss.SetHeader(metadata.Pairs(requestIDHeader, id))
+
+		started := time.Now()
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		i.finish(ctx, started, err)
+		return err
+	}
+}
+
+func (i *LoggingInterceptor) start(ctx context.Context, method string) (context.Context, string) {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIDHeader); len(values) > 0 && validRequestID(values[0]) {
+			id = values[0]
+		}
+	}
+	if id == "" {
+		id = newRequestID()
+	}
+
+	logger := i.logger.
+		WithField("request_id", id).
+		WithField("method", method).
+		WithField("peer", ClientIP(ctx, i.config))
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		logger = logger.WithField("trace_id", span.TraceID().String())
+	}
+
+	ctx = request.WithID(ctx, id)
+	return request.WithLogger(ctx, logger), id
+}
+
+func (i *LoggingInterceptor) finish(ctx context.Context, started time.Time, err error) {
+	code := status.Code(err)
+	entry := request.Logger(ctx).
+		WithField("code", code.String()).
+		WithField("duration_ms", float64(time.Since(started).Microseconds())/1000)
+
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		entry.Error("request failed")
+	default:
+		entry.Info("request finished")
+	}
+}
+
+// validRequestID accepts only IDs that are safe to log and send back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	request "github.com/practice-sem-2/user-service/internal/requests"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+)
+
+var getUserInfo = &grpc.UnaryServerInfo{FullMethod: "/users.User/GetUser"}
+
+func TestLoggingInterceptor_PropagatesRequestID(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	interceptor := NewLoggingInterceptor(logger, Config{}).Unary()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", "abc-123"))
+
+	_, err := interceptor(ctx, nil, getUserInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		assert.Equal(t, "abc-123", request.ID(ctx))
+		assert.Equal(t, "abc-123", request.Logger(ctx).Data["request_id"])
+		return nil, ErrUserNotFound
+	})
+
+	assert.Equal(t, ErrUserNotFound, err)
+	if assert.Len(t, hook.Entries, 1) {
+		entry := hook.LastEntry()
+		assert.Equal(t, logrus.InfoLevel, entry.Level)
+		assert.Equal(t, "abc-123", entry.Data["request_id"])
+		assert.Equal(t, "/users.User/GetUser", entry.Data["method"])
+		assert.Equal(t, codes.NotFound.String(), entry.Data["code"])
+		assert.Contains(t, entry.Data, "duration_ms")
+	}
+}
+
+func TestLoggingInterceptor_GeneratesInvalidRequestID(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	interceptor := NewLoggingInterceptor(logger, Config{}).Unary()
+
+	for _, id := range []string{"", "bad\nid", strings.Repeat("a", maxRequestIDLength+1)} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", id))
+		_, _ = interceptor(ctx, nil, getUserInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+			assert.NotEqual(t, id, request.ID(ctx))
+			assert.Len(t, request.ID(ctx), 32)
+			return nil, nil
+		})
+	}
+}
+
+func TestRecoveryInterceptor_TurnsPanicIntoInternalError(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	errs := NewErrorInterceptor().Unary()
+	recovery := NewRecoveryInterceptor().Unary()
+	ctx := request.WithLogger(context.Background(), logrus.NewEntry(logger))
+
+	_, err := errs(ctx, nil, getUserInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return recovery(ctx, req, getUserInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("boom")
+		})
+	})
+
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.NotContains(t, status.Convert(err).Message(), "boom")
+	if assert.Len(t, hook.Entries, 1) {
+		assert.Contains(t, hook.LastEntry().Data[logrus.ErrorKey].(error).Error(), "panic: boom")
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+type RequestObserver interface {
+	ObserveRequest(fullMethod string, code string, duration time.Duration)
+}
+
+// MetricsInterceptor must go first, so it observes final status codes
+type MetricsInterceptor struct {
+	observer RequestObserver
+}
+
+func NewMetricsInterceptor(observer RequestObserver) *MetricsInterceptor {
+	return &MetricsInterceptor{observer: observer}
+}
+
+func (i *MetricsInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		started := time.Now()
+		resp, err := handler(ctx, req)
+		i.observer.ObserveRequest(info.FullMethod, status.Code(err).String(), time.Since(started))
+		return resp, err
+	}
+}
+
+func (i *MetricsInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		started := time.Now()
+		err := handler(srv, ss)
+		i.observer.ObserveRequest(info.FullMethod, status.Code(err).String(), time.Since(started))
+		return err
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"runtime/debug"
+)
+
+// RecoveryInterceptor turns panics of handlers into internal errors,
+// which are logged with the stack by ErrorInterceptor
+type RecoveryInterceptor struct{}
+
+func NewRecoveryInterceptor() *RecoveryInterceptor {
+	return &RecoveryInterceptor{}
+}
+
+func (i *RecoveryInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				resp, err = nil, panicError(p)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func (i *RecoveryInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = panicError(p)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func panicError(p interface{}) error {
+	return &internalError{cause: fmt.Errorf("panic: %v\n%s", p, debug.Stack())}
+}
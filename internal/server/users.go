@@ -6,44 +6,32 @@ import (
 	"github.com/practice-sem-2/user-service/internal/models"
 	"github.com/practice-sem-2/user-service/internal/pb"
 	storage "github.com/practice-sem-2/user-service/internal/storages"
+	token "github.com/practice-sem-2/user-service/internal/tokens"
 	usecase "github.com/practice-sem-2/user-service/internal/usecases"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
 )
 
-type UserServer struct {
-	pb.UnimplementedUserServer
-	ucase *usecase.UseCase
+type Config struct {
+	// TrustProxyHeaders makes server take client address from x-forwarded-for
+	// metadata of requests from TrustedProxies
+	TrustProxyHeaders bool
+	// TrustedProxies are skipped in x-forwarded-for, so that address of
+	// the client is the rightmost one appended by the first of them.
+	// Headers of peers which are not among them are ignored
+	TrustedProxies []*net.IPNet
+	// TrustForwardedClientCert makes server authenticate services by
+	// x-forwarded-client-cert metadata. Enable it only behind a proxy
+	// which terminates mTLS and overwrites the header
+	TrustForwardedClientCert bool
 }
 
-var (
-	ErrUserNotFound          = status.Error(codes.NotFound, "user with provided username does not exist")
-	ErrUserAlreadyExists     = status.Error(codes.AlreadyExists, "user already exists")
-	ErrEmailAlreadyExists    = status.Error(codes.AlreadyExists, "provided email is already taken")
-	ErrInvalidActivationCode = status.Error(codes.InvalidArgument, "provided activation code is invalid")
-)
-
-func wrapError(err error) error {
-	errorMapper := []struct {
-		from error
-		to   error
-	}{
-		{from: storage.ErrUserNotFound, to: ErrUserNotFound},
-		{from: storage.ErrUserAlreadyExists, to: ErrUserAlreadyExists},
-		{from: storage.ErrEmailAlreadyExists, to: ErrEmailAlreadyExists},
-		{from: storage.ErrInvalidCode, to: ErrInvalidActivationCode},
-	}
-
-	if err == nil {
-		return nil
-	}
-
-	for _, mapping := range errorMapper {
-		if errors.Is(err, mapping.from) {
-			return mapping.to
-		}
-	}
-	return status.Error(codes.Internal, err.Error())
+type UserServer struct {
+	pb.UnimplementedUserServer
+	ucase  *usecase.UseCase
+	keys   *token.KeySet
+	config Config
 }
 
 func (s *UserServer) CreateUser(ctx context.Context, r *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
@@ -66,14 +54,18 @@ func (s *UserServer) CreateUser(ctx context.Context, r *pb.CreateUserRequest) (*
 
 func (s *UserServer) GetUser(ctx context.Context, r *pb.GetUserRequest) (*pb.GetUserResponse, error) {
 	var user *models.User
-	var err error
+
+	fields, err := ParseFieldMask(r.FieldMask)
+	if err != nil {
+		return nil, err
+	}
 
 	if r.Username == nil && r.Email == nil {
-		return nil, status.Error(codes.InvalidArgument, "Either username or email must be provided")
+		return nil, invalidArgument(fieldViolation("username", "either username or email must be provided"))
 	} else if r.Username != nil {
-		user, err = s.ucase.Users.GetByUsername(ctx, *r.Username)
+		user, err = s.ucase.Users.GetByUsername(ctx, *r.Username, fields...)
 	} else if r.Email != nil {
-		user, err = s.ucase.Users.GetByEmail(ctx, *r.Email)
+		user, err = s.ucase.Users.GetByEmail(ctx, *r.Email, fields...)
 	}
 
 	if err != nil {
@@ -87,7 +79,12 @@ func (s *UserServer) GetUser(ctx context.Context, r *pb.GetUserRequest) (*pb.Get
 }
 
 func (s *UserServer) GetManyUsers(ctx context.Context, r *pb.GetManyUsersRequest) (*pb.GetManyUsersResponse, error) {
-	users, err := s.ucase.Users.GetMany(ctx, r.Usernames)
+	fields, err := ParseFieldMask(r.FieldMask)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := s.ucase.Users.GetMany(ctx, r.Usernames, fields...)
 
 	var missingUsers []string = nil
 	if miss, ok := err.(*storage.MissingUsersError); ok {
@@ -105,12 +102,98 @@ func (s *UserServer) GetManyUsers(ctx context.Context, r *pb.GetManyUsersRequest
 	}, nil
 }
 
+func (s *UserServer) ListUsers(ctx context.Context, r *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	fields, err := ParseFieldMask(r.FieldMask)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := ParseOrderBy(r.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	query := models.ListUsersQuery{
+		Filter:  ParseUserFilter(r.Filter),
+		Order:   order,
+		Limit:   uint64(r.PageSize),
+		Columns: fields,
+	}
+	users, next, err := s.ucase.Users.List(ctx, query, r.PageToken)
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	data := make([]*pb.UserData, len(users))
+	for i := range users {
+		data[i] = ToUserData(&users[i])
+	}
+
+	return &pb.ListUsersResponse{
+		Users:         data,
+		NextPageToken: next,
+	}, nil
+}
+
+func (s *UserServer) SearchUsers(ctx context.Context, r *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	fields, err := ParseFieldMask(r.FieldMask)
+	if err != nil {
+		return nil, err
+	}
+
+	query := models.SearchUsersQuery{
+		Text:    r.Query,
+		Filter:  ParseUserFilter(r.Filter),
+		Limit:   uint64(r.PageSize),
+		Columns: fields,
+	}
+	matches, next, err := s.ucase.Users.Search(ctx, query, r.PageToken)
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	data := make([]*pb.UserData, len(matches))
+	for i := range matches {
+		data[i] = ToUserData(&matches[i].User)
+	}
+
+	return &pb.SearchUsersResponse{
+		Users:         data,
+		NextPageToken: next,
+	}, nil
+}
+
+func (s *UserServer) WatchUsers(r *pb.WatchUsersRequest, stream pb.User_WatchUsersServer) error {
+	ctx := stream.Context()
+	err := s.ucase.Changes.Watch(ctx, r.SinceSeq, r.Usernames, func(change models.Change) error {
+		msg, err := ToUserChange(change)
+		if err != nil {
+			return err
+		}
+		return stream.Send(msg)
+	})
+
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	return wrapError(err)
+}
+
 func (s *UserServer) ActivateUser(ctx context.Context, r *pb.ActivateRequest) (*pb.ActivateResponse, error) {
 	err := s.ucase.Users.Activate(ctx, r.Username, r.Code)
 	return &pb.ActivateResponse{}, wrapError(err)
 }
 
+func (s *UserServer) ResendActivationCode(ctx context.Context, r *pb.ResendActivationCodeRequest) (*pb.ResendActivationCodeResponse, error) {
+	err := s.ucase.Users.ResendActivationCode(ctx, r.Username)
+	return &pb.ResendActivationCodeResponse{}, wrapError(err)
+}
+
 func (s *UserServer) UpdateUser(ctx context.Context, r *pb.UpdateUserRequest) (*pb.UpdateUserResponse, error) {
+	// Password and email are changed only through
+	// ChangePassword and ChangeEmail, which verify them
 	update := models.UpdateFields{
 		Password:  nil,
 		Email:     nil,
@@ -119,6 +202,10 @@ func (s *UserServer) UpdateUser(ctx context.Context, r *pb.UpdateUserRequest) (*
 		AvatarID:  r.AvatarId,
 	}
 
+	if err := models.Validate.Struct(update); err != nil {
+		return nil, validationError(err, nil)
+	}
+
 	user, err := s.ucase.Users.Update(ctx, r.Username, update)
 
 	if err != nil {
@@ -135,21 +222,253 @@ func (s *UserServer) DeleteUser(ctx context.Context, r *pb.DeleteUserRequest) (*
 	return &pb.DeleteUserResponse{}, wrapError(err)
 }
 
-func (s *UserServer) GetUserByCredentials(ctx context.Context, r *pb.GetUserByCredentialsRequest) (*pb.GetUserResponse, error) {
-	user, err := s.ucase.Users.GetUserByCredentials(ctx, r.Username, r.Password)
+func (s *UserServer) RestoreUser(ctx context.Context, r *pb.RestoreUserRequest) (*pb.RestoreUserResponse, error) {
+	user, err := s.ucase.Users.Restore(ctx, r.Username)
 
 	if err != nil {
 		return nil, wrapError(err)
 	}
 
-	return &pb.GetUserResponse{
+	return &pb.RestoreUserResponse{
 		User: ToUserData(user),
 	}, nil
+}
 
+func (s *UserServer) GetUserByCredentials(ctx context.Context, r *pb.GetUserByCredentialsRequest) (*pb.GetUserByCredentialsResponse, error) {
+	user, err := s.ucase.Users.GetUserByCredentials(ctx, r.Username, r.Password, ClientIP(ctx, s.config))
+
+	var required *usecase.SecondFactorRequiredError
+	if errors.As(err, &required) {
+		return &pb.GetUserByCredentialsResponse{
+			Challenge: ToSecondFactorChallenge(required),
+		}, nil
+	}
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &pb.GetUserByCredentialsResponse{
+		User: ToUserData(user),
+	}, nil
+
+}
+
+func (s *UserServer) RequestPasswordReset(ctx context.Context, r *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetResponse, error) {
+	if r.Username == nil && r.Email == nil {
+		return nil, invalidArgument(fieldViolation("username", "either username or email must be provided"))
+	}
+
+	err := s.ucase.Users.RequestPasswordReset(ctx, r.Username, r.Email)
+	return &pb.RequestPasswordResetResponse{}, wrapError(err)
 }
 
-func NewUserServer(ucase *usecase.UseCase) *UserServer {
+func (s *UserServer) ConfirmPasswordReset(ctx context.Context, r *pb.ConfirmPasswordResetRequest) (*pb.ConfirmPasswordResetResponse, error) {
+	if err := ValidatePassword("password", r.Password); err != nil {
+		return nil, err
+	}
+
+	err := s.ucase.Users.ConfirmPasswordReset(ctx, r.Token, r.Password)
+	return &pb.ConfirmPasswordResetResponse{}, wrapError(err)
+}
+
+func (s *UserServer) ChangePassword(ctx context.Context, r *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	if err := ValidatePassword("new_password", r.NewPassword); err != nil {
+		return nil, err
+	}
+
+	err := s.ucase.Users.ChangePassword(ctx, r.Username, r.CurrentPassword, r.NewPassword, ClientIP(ctx, s.config))
+	return &pb.ChangePasswordResponse{}, wrapError(err)
+}
+
+func (s *UserServer) ChangeEmail(ctx context.Context, r *pb.ChangeEmailRequest) (*pb.ChangeEmailResponse, error) {
+	if err := ValidateEmail("new_email", r.NewEmail); err != nil {
+		return nil, err
+	}
+
+	err := s.ucase.Users.ChangeEmail(ctx, r.Username, r.Password, r.NewEmail, ClientIP(ctx, s.config))
+	return &pb.ChangeEmailResponse{}, wrapError(err)
+}
+
+func (s *UserServer) ConfirmEmailChange(ctx context.Context, r *pb.ConfirmEmailChangeRequest) (*pb.ConfirmEmailChangeResponse, error) {
+	user, err := s.ucase.Users.ConfirmEmailChange(ctx, r.Username, r.Code)
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &pb.ConfirmEmailChangeResponse{
+		User: ToUserData(user),
+	}, nil
+}
+
+func (s *UserServer) Login(ctx context.Context, r *pb.LoginRequest) (*pb.LoginResponse, error) {
+	user, tokens, err := s.ucase.Sessions.Login(ctx, r.Username, r.Password, ClientIP(ctx, s.config))
+
+	var required *usecase.SecondFactorRequiredError
+	if errors.As(err, &required) {
+		return &pb.LoginResponse{
+			Challenge: ToSecondFactorChallenge(required),
+		}, nil
+	}
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &pb.LoginResponse{
+		User:   ToUserData(user),
+		Tokens: ToTokenPair(tokens),
+	}, nil
+}
+
+func (s *UserServer) Refresh(ctx context.Context, r *pb.RefreshRequest) (*pb.RefreshResponse, error) {
+	tokens, err := s.ucase.Sessions.Refresh(ctx, r.RefreshToken)
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &pb.RefreshResponse{
+		Tokens: ToTokenPair(tokens),
+	}, nil
+}
+
+func (s *UserServer) Logout(ctx context.Context, r *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	err := s.ucase.Sessions.Logout(ctx, r.RefreshToken)
+	return &pb.LogoutResponse{}, wrapError(err)
+}
+
+func (s *UserServer) GetSigningKeys(_ context.Context, _ *pb.GetSigningKeysRequest) (*pb.GetSigningKeysResponse, error) {
+	jwks := s.keys.JWKS()
+	keys := make([]*pb.JsonWebKey, len(jwks.Keys))
+	for i, key := range jwks.Keys {
+		keys[i] = ToJsonWebKey(key)
+	}
+	return &pb.GetSigningKeysResponse{Keys: keys}, nil
+}
+
+func (s *UserServer) UnlockUser(ctx context.Context, r *pb.UnlockUserRequest) (*pb.UnlockUserResponse, error) {
+	err := s.ucase.Users.Unlock(ctx, r.Username)
+	return &pb.UnlockUserResponse{}, wrapError(err)
+}
+
+func NewUserServer(ucase *usecase.UseCase, keys *token.KeySet, config Config) *UserServer {
 	return &UserServer{
-		ucase: ucase,
+		ucase:  ucase,
+		keys:   keys,
+		config: config,
 	}
 }
+
+func (s *UserServer) EnrollTOTP(ctx context.Context, r *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	enrollment, err := s.ucase.Users.EnrollTOTP(ctx, r.Username, r.Password, ClientIP(ctx, s.config))
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &pb.EnrollTOTPResponse{
+		Secret: enrollment.Secret,
+		Uri:    enrollment.URI,
+	}, nil
+}
+
+func (s *UserServer) ConfirmTOTP(ctx context.Context, r *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	recoveryCodes, err := s.ucase.Users.ConfirmTOTP(ctx, r.Username, r.Code)
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &pb.ConfirmTOTPResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *UserServer) DisableTOTP(ctx context.Context, r *pb.DisableTOTPRequest) (*pb.DisableTOTPResponse, error) {
+	err := s.ucase.Users.DisableTOTP(ctx, r.Username, r.Password, r.Code, ClientIP(ctx, s.config))
+	return &pb.DisableTOTPResponse{}, wrapError(err)
+}
+
+func (s *UserServer) VerifySecondFactor(ctx context.Context, r *pb.VerifySecondFactorRequest) (*pb.VerifySecondFactorResponse, error) {
+	user, tokens, err := s.ucase.Sessions.VerifySecondFactor(ctx, r.Challenge, r.Code)
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	response := &pb.VerifySecondFactorResponse{
+		User: ToUserData(user),
+	}
+	if tokens != nil {
+		response.Tokens = ToTokenPair(tokens)
+	}
+	return response, nil
+}
+
+func (s *UserServer) GetUserRoles(ctx context.Context, r *pb.GetUserRolesRequest) (*pb.GetUserRolesResponse, error) {
+	roles, err := s.ucase.Access.GetUserRoles(ctx, r.Username)
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	data := make([]*pb.UserRole, len(roles))
+	for i, role := range roles {
+		data[i] = &pb.UserRole{
+			Role:      role.Role,
+			GrantedAt: timestamppb.New(role.GrantedAt),
+		}
+	}
+
+	return &pb.GetUserRolesResponse{
+		Roles: data,
+	}, nil
+}
+
+func (s *UserServer) AssignRole(ctx context.Context, r *pb.AssignRoleRequest) (*pb.AssignRoleResponse, error) {
+	err := s.ucase.Access.AssignRole(ctx, r.Username, r.Role)
+	return &pb.AssignRoleResponse{}, wrapError(err)
+}
+
+func (s *UserServer) RevokeRole(ctx context.Context, r *pb.RevokeRoleRequest) (*pb.RevokeRoleResponse, error) {
+	err := s.ucase.Access.RevokeRole(ctx, r.Username, r.Role)
+	return &pb.RevokeRoleResponse{}, wrapError(err)
+}
+
+func (s *UserServer) ListAuditEvents(ctx context.Context, r *pb.ListAuditEventsRequest) (*pb.ListAuditEventsResponse, error) {
+	query := models.AuditQuery{
+		Username: r.Username,
+		Actor:    r.Actor,
+		Action:   r.Action,
+		Limit:    uint64(r.PageSize),
+	}
+	if r.Since != nil {
+		since := r.Since.AsTime()
+		query.Since = &since
+	}
+	if r.Until != nil {
+		until := r.Until.AsTime()
+		query.Until = &until
+	}
+
+	events, next, err := s.ucase.Audit.List(ctx, query, r.PageToken)
+
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	data := make([]*pb.AuditEvent, len(events))
+	for i := range events {
+		data[i], err = ToAuditEvent(&events[i])
+		if err != nil {
+			return nil, wrapError(err)
+		}
+	}
+
+	return &pb.ListAuditEventsResponse{
+		Events:        data,
+		NextPageToken: next,
+	}, nil
+}
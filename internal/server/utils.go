@@ -1,8 +1,18 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"github.com/practice-sem-2/user-service/internal/models"
 	"github.com/practice-sem-2/user-service/internal/pb"
+	token "github.com/practice-sem-2/user-service/internal/tokens"
+	usecase "github.com/practice-sem-2/user-service/internal/usecases"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"strings"
 )
 
 func ParseCreateRequest(req *pb.CreateUserRequest) (models.UserCreate, error) {
@@ -22,18 +32,95 @@ func ParseCreateRequest(req *pb.CreateUserRequest) (models.UserCreate, error) {
 	if req.LastName != nil {
 		u.LastName = *req.LastName
 	}
-	err := models.Validate.Struct(u)
-	return u, err
+	if err := models.Validate.Struct(u); err != nil {
+		return u, validationError(err, nil)
+	}
+	return u, nil
+}
+
+// ValidatePassword checks password against the same rules that are
+// applied when password is updated. Field is the request field name
+func ValidatePassword(field string, password string) error {
+	err := models.Validate.StructPartial(models.UpdateFields{Password: &password}, "Password")
+	if err != nil {
+		return validationError(err, map[string]string{"Password": field})
+	}
+	return nil
+}
+
+// ValidateEmail checks email against the same rules that are
+// applied when email is updated. Field is the request field name
+func ValidateEmail(field string, email string) error {
+	err := models.Validate.StructPartial(models.UpdateFields{Email: &email}, "Email")
+	if err != nil {
+		return validationError(err, map[string]string{"Email": field})
+	}
+	return nil
+}
+
+// ParseFieldMask returns names of requested UserData fields, which are the
+// same as column names. Username is always returned regardless of the mask
+func ParseFieldMask(mask *fieldmaskpb.FieldMask) ([]string, error) {
+	if mask == nil {
+		return nil, nil
+	}
+
+	if !mask.IsValid(&pb.UserData{}) {
+		return nil, invalidArgument(fieldViolation("field_mask", fmt.Sprintf("contains unknown fields: %v", mask.GetPaths())))
+	}
+
+	mask.Normalize()
+	return mask.GetPaths(), nil
+}
+
+// ParseOrderBy accepts column name optionally followed by "desc" or "asc"
+func ParseOrderBy(orderBy string) (models.UserOrder, error) {
+	parts := strings.Fields(orderBy)
+	switch {
+	case len(parts) == 0:
+		return models.UserOrder{}, nil
+	case len(parts) == 1:
+		return models.UserOrder{Column: parts[0]}, nil
+	case len(parts) == 2 && strings.EqualFold(parts[1], "asc"):
+		return models.UserOrder{Column: parts[0]}, nil
+	case len(parts) == 2 && strings.EqualFold(parts[1], "desc"):
+		return models.UserOrder{Column: parts[0], Desc: true}, nil
+	default:
+		return models.UserOrder{}, invalidArgument(fieldViolation("order_by", fmt.Sprintf("must be a field optionally followed by asc or desc, got %q", orderBy)))
+	}
+}
+
+func ParseUserFilter(filter *pb.UserFilter) models.UserFilter {
+	if filter == nil {
+		return models.UserFilter{}
+	}
+
+	f := models.UserFilter{
+		IsActive:    filter.IsActive,
+		EmailDomain: filter.EmailDomain,
+		NamePrefix:  filter.NamePrefix,
+	}
+
+	if filter.CreatedAfter != nil {
+		after := filter.CreatedAfter.AsTime()
+		f.CreatedAfter = &after
+	}
+
+	if filter.CreatedBefore != nil {
+		before := filter.CreatedBefore.AsTime()
+		f.CreatedBefore = &before
+	}
+	return f
 }
 
+// ToUserData never exposes password hash, passwords are verified only by the service
 func ToUserData(user *models.User) *pb.UserData {
 	data := pb.UserData{
-		Username:     user.Username,
-		Email:        user.Email,
-		PasswordHash: user.PasswordHash,
-		AvatarId:     user.AvatarID,
-		FirstName:    nil,
-		LastName:     nil,
+		Username:  user.Username,
+		Email:     user.Email,
+		AvatarId:  user.AvatarID,
+		FirstName: nil,
+		LastName:  nil,
 	}
 
 	if user.FirstName != "" {
@@ -43,5 +130,165 @@ func ToUserData(user *models.User) *pb.UserData {
 	if user.LastName != "" {
 		data.LastName = &user.LastName
 	}
+
+	if !user.CreatedAt.IsZero() {
+		data.CreatedAt = timestamppb.New(user.CreatedAt)
+	}
 	return &data
 }
+
+func ToTokenPair(pair *usecase.TokenPair) *pb.TokenPair {
+	return &pb.TokenPair{
+		AccessToken:           pair.AccessToken,
+		AccessTokenExpiresAt:  timestamppb.New(pair.AccessTokenExpiresAt),
+		RefreshToken:          pair.RefreshToken,
+		RefreshTokenExpiresAt: timestamppb.New(pair.RefreshTokenExpiresAt),
+	}
+}
+
+func ToSecondFactorChallenge(e *usecase.SecondFactorRequiredError) *pb.SecondFactorChallenge {
+	return &pb.SecondFactorChallenge{
+		Challenge: e.Challenge,
+		ExpiresAt: timestamppb.New(e.ExpiresAt),
+	}
+}
+
+// changeTypes maps event types to types of change watchers see.
+// Activation is only an update for them and restored user appears again
+var changeTypes = map[string]pb.UserChange_Type{
+	models.EventUserCreated:   pb.UserChange_CREATED,
+	models.EventUserUpdated:   pb.UserChange_UPDATED,
+	models.EventUserActivated: pb.UserChange_UPDATED,
+	models.EventUserDeleted:   pb.UserChange_DELETED,
+	models.EventUserRestored:  pb.UserChange_CREATED,
+	models.EventUserPurged:    pb.UserChange_PURGED,
+}
+
+func ToUserChange(change models.Change) (*pb.UserChange, error) {
+	payload, err := change.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &pb.UserChange{
+		Seq:        change.Seq,
+		Type:       changeTypes[change.Type],
+		Username:   change.Username,
+		OccurredAt: timestamppb.New(payload.OccurredAt),
+	}
+
+	if snapshot := payload.User; snapshot != nil {
+		msg.User = ToUserData(&models.User{
+			Username:  snapshot.Username,
+			Email:     snapshot.Email,
+			FirstName: snapshot.FirstName,
+			LastName:  snapshot.LastName,
+			AvatarID:  snapshot.AvatarID,
+			IsActive:  snapshot.IsActive,
+			CreatedAt: snapshot.CreatedAt,
+		})
+	}
+	return msg, nil
+}
+
+func ToAuditEvent(event *models.AuditEvent) (*pb.AuditEvent, error) {
+	changes, err := event.DecodeChanges()
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]*pb.FieldChange, len(changes))
+	for i, change := range changes {
+		data[i] = &pb.FieldChange{
+			Field:    change.Field,
+			OldValue: change.Old,
+			NewValue: change.New,
+		}
+	}
+
+	return &pb.AuditEvent{
+		Id:          event.ID,
+		Actor:       event.Actor,
+		Username:    event.Username,
+		Action:      event.Action,
+		Changes:     data,
+		RequestId:   event.RequestID,
+		PeerAddress: event.PeerAddress,
+		OccurredAt:  timestamppb.New(event.OccurredAt),
+	}, nil
+}
+
+func ToJsonWebKey(key token.JWK) *pb.JsonWebKey {
+	return &pb.JsonWebKey{
+		Kty: key.KeyType,
+		Kid: key.KeyID,
+		Alg: key.Algorithm,
+		Use: key.Use,
+		Crv: key.Curve,
+		X:   key.X,
+		N:   key.Modulus,
+		E:   key.Exponent,
+	}
+}
+
+// ClientIP returns address of the client or empty string if it is unknown.
+// Proxy headers are used only if TrustProxyHeaders is set and the peer is
+// one of TrustedProxies, because clients can send them directly
+func ClientIP(ctx context.Context, config Config) string {
+	address := peerAddress(ctx)
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || !config.TrustProxyHeaders {
+		return address
+	}
+
+	if ip := net.ParseIP(address); ip != nil && containsIP(config.TrustedProxies, ip) {
+		// Peer is the last hop, which isn't in the header it sent
+		values := append(append([]string{}, md.Get("x-forwarded-for")...), address)
+		if ip := forwardedClientIP(values, config.TrustedProxies); ip != "" {
+			return ip
+		}
+	}
+	return address
+}
+
+func peerAddress(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return p.Addr.String()
+		}
+		return host
+	}
+	return ""
+}
+
+// forwardedClientIP returns the rightmost address which is not a trusted
+// proxy. Every proxy appends address it got request from, so addresses
+// on the left could be sent by the client itself
+func forwardedClientIP(values []string, proxies []*net.IPNet) string {
+	var addresses []string
+	for _, value := range values {
+		addresses = append(addresses, strings.Split(value, ",")...)
+	}
+
+	for i := len(addresses) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(addresses[i]))
+		if ip == nil {
+			return ""
+		}
+		if !containsIP(proxies, ip) {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"testing"
+)
+
+func withForwardedFor(values ...string) context.Context {
+	return fromPeer("10.0.0.2", values...)
+}
+
+func fromPeer(address string, values ...string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(address), Port: 4000}})
+	md := metadata.MD{}
+	if len(values) > 0 {
+		md.Set("x-forwarded-for", values...)
+	}
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+func TestClientIP_IgnoresHeadersUnlessTrusted(t *testing.T) {
+	assert.Equal(t, "10.0.0.2", ClientIP(withForwardedFor("192.0.2.1"), Config{}))
+}
+
+func TestClientIP_TakesRightmostUntrustedAddress(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	config := Config{TrustProxyHeaders: true, TrustedProxies: []*net.IPNet{proxies}}
+
+	// Client put the first address on its own
+	assert.Equal(t, "192.0.2.1", ClientIP(withForwardedFor("203.0.113.9, 192.0.2.1"), config))
+	assert.Equal(t, "192.0.2.1", ClientIP(withForwardedFor("203.0.113.9, 192.0.2.1, 10.1.1.1"), config))
+	assert.Equal(t, "192.0.2.1", ClientIP(withForwardedFor("203.0.113.9", "192.0.2.1, 10.1.1.1"), config))
+}
+
+func TestClientIP_IgnoresHeadersOfUntrustedPeer(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	config := Config{TrustProxyHeaders: true, TrustedProxies: []*net.IPNet{proxies}}
+
+	// Client connected directly and forged the header
+	assert.Equal(t, "198.51.100.7", ClientIP(fromPeer("198.51.100.7", "192.0.2.1"), config))
+	assert.Equal(t, "198.51.100.7", ClientIP(fromPeer("198.51.100.7", "192.0.2.1, 10.1.1.1"), config))
+	assert.Equal(t, "10.0.0.2", ClientIP(withForwardedFor("192.0.2.1"), Config{TrustProxyHeaders: true}), "no proxies are trusted")
+}
+
+func TestClientIP_FallsBackToPeer(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	config := Config{TrustProxyHeaders: true, TrustedProxies: []*net.IPNet{proxies}}
+
+	assert.Equal(t, "10.0.0.2", ClientIP(withForwardedFor(), config))
+	assert.Equal(t, "10.0.0.2", ClientIP(withForwardedFor("10.1.1.1"), config), "all addresses are proxies")
+	assert.Equal(t, "10.0.0.2", ClientIP(withForwardedFor("192.0.2.1, garbage"), config))
+
+	ctx := metadata.NewIncomingContext(withForwardedFor(), metadata.Pairs("x-real-ip", "192.0.2.1"))
+	assert.Equal(t, "10.0.0.2", ClientIP(ctx, config), "x-real-ip is not trusted")
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"time"
+)
+
+type ActivationCodeStorage struct {
+	db         Scope
+	selectCode sq.SelectBuilder
+	insertCode sq.InsertBuilder
+	updateCode sq.UpdateBuilder
+	deleteCode sq.DeleteBuilder
+}
+
+func NewActivationCodeStorage(db Scope) ActivationCodeStorage {
+	return ActivationCodeStorage{
+		db:         db,
+		selectCode: sq.Select(activationCodeColumns).From("users_activation_codes").PlaceholderFormat(sq.Dollar),
+		insertCode: sq.Insert("users_activation_codes").PlaceholderFormat(sq.Dollar),
+		updateCode: sq.Update("users_activation_codes").PlaceholderFormat(sq.Dollar),
+		deleteCode: sq.Delete("users_activation_codes").PlaceholderFormat(sq.Dollar),
+	}
+}
+
+const activationCodeColumns = "id, username, code_hash, attempts, created_at, expires_at"
+
+var ErrCodeNotFound = errors.New("activation code not found")
+
+func (s *ActivationCodeStorage) CreateActivationCode(ctx context.Context, username string, codeHash string, ttl time.Duration) (*models.ActivationCode, error) {
+	now := time.Now()
+	query, args, err := s.insertCode.
+		Columns("username", "code_hash", "created_at", "expires_at").
+		Values(username, codeHash, now, now.Add(ttl)).
+		Suffix("RETURNING " + activationCodeColumns).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var created models.ActivationCode
+	err = s.db.GetContext(ctx, &created, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetLastActivationCode returns the most recently issued code of user
+func (s *ActivationCodeStorage) GetLastActivationCode(ctx context.Context, username string) (*models.ActivationCode, error) {
+	query, args, err := s.selectCode.
+		Where(sq.Eq{"username": username}).
+		OrderBy("created_at DESC").
+		Limit(1).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var code models.ActivationCode
+	err = s.db.GetContext(ctx, &code, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCodeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// IncrementActivationAttempts records failed attempt to use the code
+func (s *ActivationCodeStorage) IncrementActivationAttempts(ctx context.Context, id int64) error {
+	query, args, err := s.updateCode.
+		Set("attempts", sq.Expr("attempts + 1")).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *ActivationCodeStorage) DeleteActivationCodes(ctx context.Context, username string) error {
+	query, args, err := s.deleteCode.Where(sq.Eq{"username": username}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/practice-sem-2/user-service/internal/models"
+)
+
+type AuditStorage struct {
+	db          Scope
+	selectEvent sq.SelectBuilder
+	insertEvent sq.InsertBuilder
+}
+
+func NewAuditStorage(db Scope) AuditStorage {
+	return AuditStorage{
+		db: db,
+		selectEvent: sq.
+			Select("id", "actor", "username", "action", "changes", "request_id", "peer_address", "occurred_at").
+			From("audit_events").
+			PlaceholderFormat(sq.Dollar),
+		insertEvent: sq.Insert("audit_events").PlaceholderFormat(sq.Dollar),
+	}
+}
+
+// AddAuditEvent must be called within the same transaction as the change
+func (s *AuditStorage) AddAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	query, args, err := s.insertEvent.
+		Columns("actor", "username", "action", "changes", "request_id", "peer_address").
+		Values(event.Actor, event.Username, event.Action, string(event.Changes), event.RequestID, event.PeerAddress).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *AuditStorage) ListAuditEvents(ctx context.Context, q models.AuditQuery) ([]models.AuditEvent, error) {
+	where := sq.And{}
+	if q.Username != "" {
+		where = append(where, sq.Eq{"username": q.Username})
+	}
+	if q.Actor != "" {
+		where = append(where, sq.Eq{"actor": q.Actor})
+	}
+	if q.Action != "" {
+		where = append(where, sq.Eq{"action": q.Action})
+	}
+	if q.Since != nil {
+		where = append(where, sq.GtOrEq{"occurred_at": *q.Since})
+	}
+	if q.Until != nil {
+		where = append(where, sq.Lt{"occurred_at": *q.Until})
+	}
+	if q.BeforeID != 0 {
+		where = append(where, sq.Lt{"id": q.BeforeID})
+	}
+
+	query, args, err := s.selectEvent.
+		Where(where).
+		OrderBy("id DESC").
+		Limit(q.Limit).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]models.AuditEvent, 0, q.Limit)
+	err = s.db.SelectContext(ctx, &events, query, args...)
+	return events, err
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"time"
+)
+
+// changesLockKey identifies advisory lock held while change is written
+const changesLockKey = 0x6368616e676573
+
+// ChangesChannel is notified with sequence number of every new change
+const ChangesChannel = "user_changes"
+
+type ChangeStorage struct {
+	db           Scope
+	selectChange sq.SelectBuilder
+	insertChange sq.InsertBuilder
+	deleteChange sq.DeleteBuilder
+	// pending is set within transaction, see AddChange
+	pending *[]models.Event
+}
+
+func NewChangeStorage(db Scope) ChangeStorage {
+	return ChangeStorage{
+		db:           db,
+		selectChange: sq.Select("seq", "event_type", "username", "payload", "created_at").From("user_changes").PlaceholderFormat(sq.Dollar),
+		insertChange: sq.Insert("user_changes").PlaceholderFormat(sq.Dollar),
+		deleteChange: sq.Delete("user_changes").PlaceholderFormat(sq.Dollar),
+	}
+}
+
+// AddChange must be called within the same transaction as the change.
+// Within Atomic change is written by flushChanges right before commit, so
+// the lock ordering changes is not held while the rest of transaction
+// runs, for example while notifications are sent
+func (s *ChangeStorage) AddChange(ctx context.Context, event *models.Event) error {
+	if s.pending != nil {
+		*s.pending = append(*s.pending, *event)
+		return nil
+	}
+	return s.insertChanges(ctx, []models.Event{*event})
+}
+
+// flushChanges writes changes added within transaction.
+// It must be the last statement before commit
+func (s *ChangeStorage) flushChanges(ctx context.Context) error {
+	if s.pending == nil || len(*s.pending) == 0 {
+		return nil
+	}
+	events := *s.pending
+	*s.pending = nil
+	return s.insertChanges(ctx, events)
+}
+
+// insertChanges serializes writers until commit, so changes become visible
+// in order of their sequence numbers and a watcher never skips a late commit
+func (s *ChangeStorage) insertChanges(ctx context.Context, events []models.Event) error {
+	if _, err := s.db.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", changesLockKey); err != nil {
+		return err
+	}
+
+	builder := s.insertChange.Columns("event_type", "username", "payload")
+	for _, event := range events {
+		builder = builder.Values(event.Type, event.Username, string(event.Payload))
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetChangesSince returns changes after seq in order. If usernames are
+// provided, only changes of these users are returned
+func (s *ChangeStorage) GetChangesSince(ctx context.Context, seq int64, usernames []string, limit uint64) ([]models.Change, error) {
+	builder := s.selectChange.Where(sq.Gt{"seq": seq})
+	if len(usernames) > 0 {
+		builder = builder.Where(sq.Eq{"username": usernames})
+	}
+
+	query, args, err := builder.OrderBy("seq").Limit(limit).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]models.Change, 0, limit)
+	err = s.db.SelectContext(ctx, &changes, query, args...)
+	return changes, err
+}
+
+// GetChangeSeqRange returns sequence numbers of the oldest and the
+// latest kept changes, both are zero if there were no changes yet
+func (s *ChangeStorage) GetChangeSeqRange(ctx context.Context) (oldest int64, latest int64, err error) {
+	var seqRange struct {
+		Oldest int64 `db:"oldest"`
+		Latest int64 `db:"latest"`
+	}
+	err = s.db.GetContext(ctx, &seqRange, "SELECT COALESCE(MIN(seq), 0) AS oldest, COALESCE(MAX(seq), 0) AS latest FROM user_changes")
+	return seqRange.Oldest, seqRange.Latest, err
+}
+
+// DeleteChangesBefore removes old changes, but always keeps the latest
+// one, so it is possible to tell whether watcher has missed anything
+func (s *ChangeStorage) DeleteChangesBefore(ctx context.Context, before time.Time) (int64, error) {
+	query, args, err := s.deleteChange.
+		Where(sq.Lt{"created_at": before}).
+		Where("seq < (SELECT MAX(seq) FROM user_changes)").
+		ToSql()
+
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListenChanges calls notify once listening is started and then on every
+// committed change. It blocks until connection fails or ctx is done.
+// Notifications sent while not listening are lost, so after reconnect
+// callers must check for changes on their own, which the first call is for
+func ListenChanges(ctx context.Context, dsn string, notify func()) error {
+	config, err := pgx.ParseConnectionString(dsn)
+	if err != nil {
+		return err
+	}
+
+	conn, err := pgx.Connect(config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err = conn.Listen(ChangesChannel); err != nil {
+		return err
+	}
+
+	notify()
+	for {
+		if _, err = conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		notify()
+	}
+}
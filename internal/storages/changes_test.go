@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// execScope records executed statements, methods not overridden panic
+type execScope struct {
+	Scope
+	queries []string
+	args    [][]interface{}
+}
+
+func (s *execScope) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	s.queries = append(s.queries, query)
+	s.args = append(s.args, args)
+	return nil, nil
+}
+
+func TestChangeStorage_WritesPendingChangesOnFlush(t *testing.T) {
+	db := &execScope{}
+	changes := NewChangeStorage(db)
+	changes.pending = new([]models.Event)
+
+	ctx := context.Background()
+	assert.NoError(t, changes.AddChange(ctx, &models.Event{Type: "user.created", Username: "joe", Payload: []byte("{}")}))
+	assert.NoError(t, changes.AddChange(ctx, &models.Event{Type: "user.updated", Username: "joe", Payload: []byte("{}")}))
+	assert.Empty(t, db.queries, "Should not take lock before commit")
+
+	assert.NoError(t, changes.flushChanges(ctx))
+	if assert.Len(t, db.queries, 2) {
+		assert.Contains(t, db.queries[0], "pg_advisory_xact_lock")
+		assert.Contains(t, db.queries[1], "INSERT INTO user_changes")
+		assert.Len(t, db.args[1], 6, "Should insert both changes at once")
+	}
+
+	assert.NoError(t, changes.flushChanges(ctx))
+	assert.Len(t, db.queries, 2, "Should write changes once")
+}
+
+func TestChangeStorage_WritesImmediatelyOutsideTransaction(t *testing.T) {
+	db := &execScope{}
+	changes := NewChangeStorage(db)
+
+	assert.NoError(t, changes.AddChange(context.Background(), &models.Event{Type: "user.created", Username: "joe"}))
+	assert.Len(t, db.queries, 2)
+}
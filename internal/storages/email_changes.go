@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"time"
+)
+
+type EmailChangeStorage struct {
+	db           Scope
+	selectChange sq.SelectBuilder
+	insertChange sq.InsertBuilder
+	updateChange sq.UpdateBuilder
+	deleteChange sq.DeleteBuilder
+}
+
+func NewEmailChangeStorage(db Scope) EmailChangeStorage {
+	return EmailChangeStorage{
+		db:           db,
+		selectChange: sq.Select("username", "new_email", "code_hash", "attempts", "created_at", "expires_at").From("pending_email_changes").PlaceholderFormat(sq.Dollar),
+		insertChange: sq.Insert("pending_email_changes").PlaceholderFormat(sq.Dollar),
+		updateChange: sq.Update("pending_email_changes").PlaceholderFormat(sq.Dollar),
+		deleteChange: sq.Delete("pending_email_changes").PlaceholderFormat(sq.Dollar),
+	}
+}
+
+var ErrNoPendingEmailChange = errors.New("there is no pending email change")
+
+// SetPendingEmailChange parks new email of user until it is confirmed.
+// Previous pending change of the same user is replaced
+func (s *EmailChangeStorage) SetPendingEmailChange(ctx context.Context, username string, email string, codeHash string, ttl time.Duration) error {
+	now := time.Now()
+	query, args, err := s.insertChange.
+		Columns("username", "new_email", "code_hash", "attempts", "created_at", "expires_at").
+		Values(username, email, codeHash, 0, now, now.Add(ttl)).
+		Suffix("ON CONFLICT (username) DO UPDATE SET " +
+			"new_email = EXCLUDED.new_email, " +
+			"code_hash = EXCLUDED.code_hash, " +
+			"attempts = EXCLUDED.attempts, " +
+			"created_at = EXCLUDED.created_at, " +
+			"expires_at = EXCLUDED.expires_at").
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *EmailChangeStorage) GetPendingEmailChange(ctx context.Context, username string) (*models.EmailChange, error) {
+	query, args, err := s.selectChange.
+		Where(sq.Eq{"username": username}).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var change models.EmailChange
+	err = s.db.GetContext(ctx, &change, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoPendingEmailChange
+	} else if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+func (s *EmailChangeStorage) IncrementEmailChangeAttempts(ctx context.Context, username string) error {
+	query, args, err := s.updateChange.
+		Set("attempts", sq.Expr("attempts + 1")).
+		Where(sq.Eq{"username": username}).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *EmailChangeStorage) DeletePendingEmailChange(ctx context.Context, username string) error {
+	query, args, err := s.deleteChange.Where(sq.Eq{"username": username}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/practice-sem-2/user-service/internal/models"
+)
+
+type LoginAttemptStorage struct {
+	db            Scope
+	selectAttempt sq.SelectBuilder
+	insertAttempt sq.InsertBuilder
+	deleteAttempt sq.DeleteBuilder
+}
+
+func NewLoginAttemptStorage(db Scope) LoginAttemptStorage {
+	return LoginAttemptStorage{
+		db:            db,
+		selectAttempt: sq.Select("kind", "subject", "failures", "last_failure_at", "locked_until").From("login_attempts").PlaceholderFormat(sq.Dollar),
+		insertAttempt: sq.Insert("login_attempts").PlaceholderFormat(sq.Dollar),
+		deleteAttempt: sq.Delete("login_attempts").PlaceholderFormat(sq.Dollar),
+	}
+}
+
+// GetLoginAttempts returns failed login attempts of the subject. If there
+// were none, zero counter is returned. When forUpdate is set, counter row
+// is locked until the end of transaction
+func (s *LoginAttemptStorage) GetLoginAttempts(ctx context.Context, kind string, subject string, forUpdate bool) (*models.LoginAttempts, error) {
+	builder := s.selectAttempt.Where(sq.Eq{"kind": kind, "subject": subject})
+	if forUpdate {
+		builder = builder.Suffix("FOR UPDATE")
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var attempts models.LoginAttempts
+	err = s.db.GetContext(ctx, &attempts, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &models.LoginAttempts{Kind: kind, Subject: subject}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+func (s *LoginAttemptStorage) SaveLoginAttempts(ctx context.Context, attempts *models.LoginAttempts) error {
+	query, args, err := s.insertAttempt.
+		Columns("kind", "subject", "failures", "last_failure_at", "locked_until").
+		Values(attempts.Kind, attempts.Subject, attempts.Failures, attempts.LastFailureAt, attempts.LockedUntil).
+		Suffix("ON CONFLICT (kind, subject) DO UPDATE SET " +
+			"failures = EXCLUDED.failures, " +
+			"last_failure_at = EXCLUDED.last_failure_at, " +
+			"locked_until = EXCLUDED.locked_until").
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *LoginAttemptStorage) ResetLoginAttempts(ctx context.Context, kind string, subject string) error {
+	query, args, err := s.deleteAttempt.Where(sq.Eq{"kind": kind, "subject": subject}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/practice-sem-2/user-service/internal/models"
+)
+
+// outboxLockKey identifies advisory lock held by relay
+const outboxLockKey = 0x6f7574626f78
+
+type OutboxStorage struct {
+	db          Scope
+	selectEvent sq.SelectBuilder
+	insertEvent sq.InsertBuilder
+	deleteEvent sq.DeleteBuilder
+}
+
+func NewOutboxStorage(db Scope) OutboxStorage {
+	return OutboxStorage{
+		db:          db,
+		selectEvent: sq.Select("id", "event_type", "username", "payload", "created_at").From("outbox").PlaceholderFormat(sq.Dollar),
+		insertEvent: sq.Insert("outbox").PlaceholderFormat(sq.Dollar),
+		deleteEvent: sq.Delete("outbox").PlaceholderFormat(sq.Dollar),
+	}
+}
+
+// AddEvent must be called within the same transaction as the change
+func (s *OutboxStorage) AddEvent(ctx context.Context, event *models.Event) error {
+	query, args, err := s.insertEvent.
+		Columns("event_type", "username", "payload").
+		Values(event.Type, event.Username, string(event.Payload)).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// LockOutbox makes sure that only one relay publishes events at a time,
+// otherwise events of one user could be published out of order. Returns
+// false if lock is held by someone else. Lock is released with transaction
+func (s *OutboxStorage) LockOutbox(ctx context.Context) (bool, error) {
+	var locked bool
+	err := s.db.GetContext(ctx, &locked, "SELECT pg_try_advisory_xact_lock($1)", outboxLockKey)
+	return locked, err
+}
+
+// GetPendingEvents returns the oldest events first
+func (s *OutboxStorage) GetPendingEvents(ctx context.Context, limit uint64) ([]models.Event, error) {
+	query, args, err := s.selectEvent.OrderBy("id").Limit(limit).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]models.Event, 0, limit)
+	err = s.db.SelectContext(ctx, &events, query, args...)
+	return events, err
+}
+
+func (s *OutboxStorage) DeleteEvents(ctx context.Context, ids []int64) error {
+	query, args, err := s.deleteEvent.Where(sq.Eq{"id": ids}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"time"
+)
+
+type PasswordResetStorage struct {
+	db          Scope
+	selectToken sq.SelectBuilder
+	insertToken sq.InsertBuilder
+	deleteToken sq.DeleteBuilder
+}
+
+func NewPasswordResetStorage(db Scope) PasswordResetStorage {
+	return PasswordResetStorage{
+		db:          db,
+		selectToken: sq.Select("token_hash", "username", "created_at", "expires_at").From("password_reset_tokens").PlaceholderFormat(sq.Dollar),
+		insertToken: sq.Insert("password_reset_tokens").PlaceholderFormat(sq.Dollar),
+		deleteToken: sq.Delete("password_reset_tokens").PlaceholderFormat(sq.Dollar),
+	}
+}
+
+var ErrTokenNotFound = errors.New("password reset token not found")
+
+func (s *PasswordResetStorage) CreatePasswordResetToken(ctx context.Context, username string, tokenHash string, ttl time.Duration) error {
+	now := time.Now()
+	query, args, err := s.insertToken.
+		Columns("token_hash", "username", "created_at", "expires_at").
+		Values(tokenHash, username, now, now.Add(ttl)).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetPasswordResetToken finds token by its hash and locks it until
+// the end of transaction, so it can't be redeemed twice concurrently
+func (s *PasswordResetStorage) GetPasswordResetToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	query, args, err := s.selectToken.
+		Where(sq.Eq{"token_hash": tokenHash}).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var token models.PasswordResetToken
+	err = s.db.GetContext(ctx, &token, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *PasswordResetStorage) DeletePasswordResetTokens(ctx context.Context, username string) error {
+	query, args, err := s.deleteToken.Where(sq.Eq{"username": username}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"time"
+)
+
+type RefreshTokenStorage struct {
+	db          Scope
+	selectToken sq.SelectBuilder
+	insertToken sq.InsertBuilder
+	updateToken sq.UpdateBuilder
+}
+
+func NewRefreshTokenStorage(db Scope) RefreshTokenStorage {
+	return RefreshTokenStorage{
+		db:          db,
+		selectToken: sq.Select("token_hash", "username", "family_id", "created_at", "expires_at", "used_at", "revoked_at").From("refresh_tokens").PlaceholderFormat(sq.Dollar),
+		insertToken: sq.Insert("refresh_tokens").PlaceholderFormat(sq.Dollar),
+		updateToken: sq.Update("refresh_tokens").PlaceholderFormat(sq.Dollar),
+	}
+}
+
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+func (s *RefreshTokenStorage) CreateRefreshToken(ctx context.Context, username string, tokenHash string, familyID string, ttl time.Duration) (*models.RefreshToken, error) {
+	now := time.Now()
+	query, args, err := s.insertToken.
+		Columns("token_hash", "username", "family_id", "created_at", "expires_at").
+		Values(tokenHash, username, familyID, now, now.Add(ttl)).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = s.db.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	return &models.RefreshToken{
+		TokenHash: tokenHash,
+		Username:  username,
+		FamilyID:  familyID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+// GetRefreshToken finds token by its hash and locks it
+// until the end of transaction
+func (s *RefreshTokenStorage) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	query, args, err := s.selectToken.
+		Where(sq.Eq{"token_hash": tokenHash}).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var token models.RefreshToken
+	err = s.db.GetContext(ctx, &token, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefreshTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed records that token has been exchanged for a new one
+func (s *RefreshTokenStorage) MarkRefreshTokenUsed(ctx context.Context, tokenHash string) error {
+	query, args, err := s.updateToken.
+		Set("used_at", time.Now()).
+		Where(sq.Eq{"token_hash": tokenHash}).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *RefreshTokenStorage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	return s.revoke(ctx, sq.Eq{"family_id": familyID})
+}
+
+// RevokeUserRefreshTokens logs user out of all sessions
+func (s *RefreshTokenStorage) RevokeUserRefreshTokens(ctx context.Context, username string) error {
+	return s.revoke(ctx, sq.Eq{"username": username})
+}
+
+func (s *RefreshTokenStorage) revoke(ctx context.Context, where sq.Eq) error {
+	query, args, err := s.updateToken.
+		Set("revoked_at", time.Now()).
+		Where(where).
+		Where(sq.Eq{"revoked_at": nil}).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/jackc/pgx"
+	request "github.com/practice-sem-2/user-service/internal/requests"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"math/rand"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const retryBaseDelay = 20 * time.Millisecond
+
+// beginError marks failure to start transaction, when nothing was sent yet
+type beginError struct {
+	err error
+}
+
+func (e *beginError) Error() string {
+	return e.err.Error()
+}
+
+func (e *beginError) Unwrap() error {
+	return e.err
+}
+
+// isTransient tells whether transaction certainly was not committed and
+// is likely to succeed if it is simply retried. Connection errors are
+// transient only before the first statement, afterwards they may come
+// from COMMIT, which could have succeeded
+func isTransient(err error) bool {
+	var begin *beginError
+	if errors.As(err, &begin) {
+		return isConnectionError(begin.err)
+	}
+
+	var pgErr pgx.PgError
+	// serialization_failure and deadlock_detected
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+func isConnectionError(err error) bool {
+	var pgErr pgx.PgError
+	if errors.As(err, &pgErr) {
+		// admin_shutdown and connection exceptions
+		return pgErr.Code == "57P01" || strings.HasPrefix(pgErr.Code, "08")
+	}
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// retryDelay doubles with each attempt, jitter keeps
+// conflicting transactions from retrying in lockstep
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryTransaction calls run until it succeeds, fails for a reason which
+// is not transient or maxRetries are made. Returned error is never beginError
+func retryTransaction(ctx context.Context, maxRetries int, run func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := run()
+		if err == nil || attempt >= maxRetries || !isTransient(err) {
+			var begin *beginError
+			if errors.As(err, &begin) {
+				return begin.err
+			}
+			return err
+		}
+
+		trace.SpanFromContext(ctx).AddEvent("retrying transaction", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1),
+			attribute.String("error", err.Error()),
+		))
+		request.Logger(ctx).WithError(err).WithField("attempt", attempt+1).Warning("retrying transaction")
+		select {
+		case <-time.After(retryDelay(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
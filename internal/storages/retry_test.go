@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx"
+	request "github.com/practice-sem-2/user-service/internal/requests"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsTransient_RetriesSerializationFailuresAndDeadlocks(t *testing.T) {
+	assert.True(t, isTransient(pgx.PgError{Code: "40001"}))
+	assert.True(t, isTransient(pgx.PgError{Code: "40P01"}))
+	assert.True(t, isTransient(fmt.Errorf("rollback caused by error: %w", pgx.PgError{Code: "40001"})))
+	assert.False(t, isTransient(pgx.PgError{Code: "23505"}), "Should not retry constraint violations")
+	assert.False(t, isTransient(ErrUserNotFound))
+	assert.False(t, isTransient(nil))
+}
+
+func TestIsTransient_RetriesConnectionErrorsOnlyOnBegin(t *testing.T) {
+	connErrors := []error{
+		driver.ErrBadConn,
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		syscall.ECONNRESET,
+		syscall.EPIPE,
+		pgx.PgError{Code: "08006"},
+		pgx.PgError{Code: "57P01"},
+	}
+
+	for _, err := range connErrors {
+		assert.True(t, isTransient(&beginError{err: err}), err.Error())
+		assert.False(t, isTransient(err), "Should not retry %v, it may come from COMMIT", err)
+	}
+	assert.False(t, isTransient(&beginError{err: context.Canceled}))
+}
+
+func TestRetryDelay_GrowsWithJitter(t *testing.T) {
+	for attempt := 0; attempt < 5; attempt++ {
+		base := retryBaseDelay << attempt
+		for i := 0; i < 100; i++ {
+			delay := retryDelay(attempt)
+			assert.GreaterOrEqual(t, delay, base/2)
+			assert.LessOrEqual(t, delay, base)
+		}
+	}
+}
+
+func TestRetryTransaction_StopsOnSuccess(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	ctx := request.WithLogger(context.Background(), logrus.NewEntry(logger))
+
+	calls := 0
+	err := retryTransaction(ctx, 3, func() error {
+		calls++
+		if calls < 2 {
+			return pgx.PgError{Code: "40001"}
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	if assert.Len(t, hook.AllEntries(), 1, "Should log retry with request logger") {
+		assert.Equal(t, 1, hook.LastEntry().Data["attempt"])
+	}
+}
+
+func TestRetryTransaction_GivesUpAfterMaxRetries(t *testing.T) {
+	calls := 0
+	err := retryTransaction(context.Background(), 2, func() error {
+		calls++
+		return &beginError{err: driver.ErrBadConn}
+	})
+
+	assert.Equal(t, driver.ErrBadConn, err, "Should unwrap begin error")
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryTransaction_DoesNotRetryOtherErrors(t *testing.T) {
+	calls := 0
+	err := retryTransaction(context.Background(), 3, func() error {
+		calls++
+		return driver.ErrBadConn
+	})
+
+	assert.Equal(t, driver.ErrBadConn, err)
+	assert.Equal(t, 1, calls, "Should not retry connection errors after begin")
+}
+
+func TestRetryTransaction_StopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	start := time.Now()
+	err := retryTransaction(ctx, 10, func() error {
+		calls++
+		cancel()
+		return pgx.PgError{Code: "40P01"}
+	})
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, calls)
+	assert.Less(t, time.Since(start), retryBaseDelay)
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx"
+	"github.com/practice-sem-2/user-service/internal/models"
+)
+
+var (
+	ErrRoleNotFound     = errors.New("role not found")
+	ErrRoleNotAssigned  = errors.New("user does not have the role")
+	ErrRoleAlreadyGiven = errors.New("user already has the role")
+)
+
+type RoleStorage struct {
+	db             Scope
+	selectUserRole sq.SelectBuilder
+	insertUserRole sq.InsertBuilder
+	deleteUserRole sq.DeleteBuilder
+}
+
+func NewRoleStorage(db Scope) RoleStorage {
+	return RoleStorage{
+		db:             db,
+		selectUserRole: sq.Select("username", "role", "granted_at").From("user_roles").PlaceholderFormat(sq.Dollar),
+		insertUserRole: sq.Insert("user_roles").PlaceholderFormat(sq.Dollar),
+		deleteUserRole: sq.Delete("user_roles").PlaceholderFormat(sq.Dollar),
+	}
+}
+
+func (s *RoleStorage) GetUserRoles(ctx context.Context, username string) ([]models.UserRole, error) {
+	query, args, err := s.selectUserRole.Where(sq.Eq{"username": username}).OrderBy("role").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]models.UserRole, 0)
+	err = s.db.SelectContext(ctx, &roles, query, args...)
+	return roles, err
+}
+
+func (s *RoleStorage) AssignRole(ctx context.Context, username string, role string) error {
+	query, args, err := s.insertUserRole.
+		Columns("username", "role").
+		Values(username, role).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	if pgErr, ok := err.(pgx.PgError); ok {
+		switch pgErr.ConstraintName {
+		case "user_roles_pkey":
+			return ErrRoleAlreadyGiven
+		case "user_roles_role_fkey":
+			return ErrRoleNotFound
+		case "user_roles_username_fkey":
+			return ErrUserNotFound
+		}
+	}
+	return err
+}
+
+func (s *RoleStorage) RevokeRole(ctx context.Context, username string, role string) error {
+	query, args, err := s.deleteUserRole.Where(sq.Eq{"username": username, "role": role}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrRoleNotAssigned
+	}
+	return nil
+}
+
+// GetRolePermissions returns permissions granted by any of the roles
+func (s *RoleStorage) GetRolePermissions(ctx context.Context, roles []string) ([]string, error) {
+	query, args, err := sq.Select("DISTINCT permission").
+		From("role_permissions").
+		Where(sq.Eq{"role": roles}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make([]string, 0)
+	err = s.db.SelectContext(ctx, &permissions, query, args...)
+	return permissions, err
+}
+
+// GetUserPermissions returns permissions of all roles of the user
+// including the implicit one, which every user has
+func (s *RoleStorage) GetUserPermissions(ctx context.Context, username string) ([]string, error) {
+	assigned := sq.Select("role").From("user_roles").Where(sq.Eq{"username": username})
+
+	query, args, err := sq.Select("DISTINCT permission").
+		From("role_permissions").
+		Where(sq.Or{
+			sq.Eq{"role": models.RoleUser},
+			sq.Expr("role IN (?)", assigned),
+		}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make([]string, 0)
+	err = s.db.SelectContext(ctx, &permissions, query, args...)
+	return permissions, err
+}
@@ -3,14 +3,40 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"time"
 )
 
+type Config struct {
+	// QueryTimeout caps duration of each query, 0 means no cap
+	QueryTimeout time.Duration
+	// MaxRetries is how many times transaction is retried after transient failure
+	MaxRetries int
+}
+
+var DefaultConfig = Config{
+	QueryTimeout: 10 * time.Second,
+	MaxRetries:   3,
+}
+
 type Storage struct {
-	db    *sqlx.DB
-	scope Scope
+	db     *sqlx.DB
+	scope  Scope
+	config Config
 	UserStorage
+	ActivationCodeStorage
+	PasswordResetStorage
+	EmailChangeStorage
+	RefreshTokenStorage
+	LoginAttemptStorage
+	TwoFactorStorage
+	OutboxStorage
+	ChangeStorage
+	RoleStorage
+	AuditStorage
 }
 
 type Scope interface {
@@ -27,18 +53,55 @@ type Scope interface {
 	NamedQuery(query string, arg interface{}) (*sqlx.Rows, error)
 }
 
-func NewStorage(db *sqlx.DB) *Storage {
-	return &Storage{
-		db:          db,
-		scope:       db,
-		UserStorage: NewUserStorage(db),
+func NewStorage(db *sqlx.DB, config Config) *Storage {
+	storage := newScopedStorage(db, limitScope(db, config.QueryTimeout))
+	storage.config = config
+	return &storage
+}
+
+func newScopedStorage(db *sqlx.DB, scope Scope) Storage {
+	return Storage{
+		db:                    db,
+		scope:                 scope,
+		UserStorage:           NewUserStorage(scope),
+		ActivationCodeStorage: NewActivationCodeStorage(scope),
+		PasswordResetStorage:  NewPasswordResetStorage(scope),
+		EmailChangeStorage:    NewEmailChangeStorage(scope),
+		RefreshTokenStorage:   NewRefreshTokenStorage(scope),
+		LoginAttemptStorage:   NewLoginAttemptStorage(scope),
+		TwoFactorStorage:      NewTwoFactorStorage(scope),
+		OutboxStorage:         NewOutboxStorage(scope),
+		ChangeStorage:         NewChangeStorage(scope),
+		RoleStorage:           NewRoleStorage(scope),
+		AuditStorage:          NewAuditStorage(scope),
+	}
+}
+
+// Atomic runs fn in transaction once. Outcome of a failed COMMIT is
+// unknown, so it is up to caller whether to try again
+func (s *Storage) Atomic(ctx context.Context, fn func(store *Storage) error) error {
+	err := s.atomic(ctx, fn)
+	var begin *beginError
+	if errors.As(err, &begin) {
+		return begin.err
 	}
+	return err
+}
+
+// AtomicRetry is like Atomic, but retries transactions which were rolled
+// back because of serialization failures or deadlocks, or couldn't be
+// started at all. fn may be called several times, so it must have no
+// effects other than through store, like sending notifications
+func (s *Storage) AtomicRetry(ctx context.Context, fn func(store *Storage) error) error {
+	return retryTransaction(ctx, s.config.MaxRetries, func() error {
+		return s.atomic(ctx, fn)
+	})
 }
 
-func (s *Storage) Atomic(ctx context.Context, fn func(store *Storage) error) (err error) {
+func (s *Storage) atomic(ctx context.Context, fn func(store *Storage) error) (err error) {
 	tx, err := s.db.BeginTxx(ctx, &sql.TxOptions{})
 	if err != nil {
-		return err
+		return &beginError{err: err}
 	}
 
 	defer func() {
@@ -48,14 +111,18 @@ func (s *Storage) Atomic(ctx context.Context, fn func(store *Storage) error) (er
 		}
 		if err != nil {
 			if rbErr := tx.Rollback(); rbErr != nil {
-				err = fmt.Errorf("rollback caused by error: \"%v\" failed: %v", err, rbErr)
+				err = fmt.Errorf("rollback caused by error: \"%w\" failed: %v", err, rbErr)
 			}
 		} else {
 			err = tx.Commit()
 		}
 	}()
 
-	storage := Storage{s.db, tx, s.UserStorage}
-	err = fn(&storage)
-	return nil
+	storage := newScopedStorage(s.db, limitScope(tx, s.config.QueryTimeout))
+	storage.config = s.config
+	storage.ChangeStorage.pending = new([]models.Event)
+	if err = fn(&storage); err != nil {
+		return err
+	}
+	return storage.flushChanges(ctx)
 }
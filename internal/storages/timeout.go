@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// timeoutScope caps duration of each query, while earlier deadline of the
+// caller is kept. Rows of QueryxContext and QueryRowxContext are read after
+// they return, so they can't be capped and storages must not use them
+type timeoutScope struct {
+	Scope
+	timeout time.Duration
+}
+
+func limitScope(db Scope, timeout time.Duration) Scope {
+	if timeout <= 0 {
+		return db
+	}
+	return timeoutScope{Scope: db, timeout: timeout}
+}
+
+func (s timeoutScope) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.Scope.GetContext(ctx, dest, query, args...)
+}
+
+func (s timeoutScope) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.Scope.SelectContext(ctx, dest, query, args...)
+}
+
+func (s timeoutScope) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.Scope.ExecContext(ctx, query, args...)
+}
+
+func (s timeoutScope) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.Scope.NamedExecContext(ctx, query, arg)
+}
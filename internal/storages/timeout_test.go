@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// deadlineScope records deadline of the last query, methods not overridden panic
+type deadlineScope struct {
+	Scope
+	deadline time.Time
+	ok       bool
+}
+
+func (s *deadlineScope) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	s.deadline, s.ok = ctx.Deadline()
+	return nil
+}
+
+func (s *deadlineScope) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	s.deadline, s.ok = ctx.Deadline()
+	return nil, nil
+}
+
+func TestTimeoutScope_CapsQueries(t *testing.T) {
+	db := &deadlineScope{}
+	scope := limitScope(db, time.Second)
+
+	start := time.Now()
+	_ = scope.GetContext(context.Background(), nil, "SELECT 1")
+	if assert.True(t, db.ok) {
+		assert.WithinDuration(t, start.Add(time.Second), db.deadline, 100*time.Millisecond)
+	}
+
+	db.ok = false
+	_, _ = scope.ExecContext(context.Background(), "SELECT 1")
+	assert.True(t, db.ok)
+}
+
+func TestTimeoutScope_KeepsEarlierDeadline(t *testing.T) {
+	db := &deadlineScope{}
+	scope := limitScope(db, time.Hour)
+
+	deadline := time.Now().Add(time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	_ = scope.GetContext(ctx, nil, "SELECT 1")
+	assert.Equal(t, deadline, db.deadline)
+}
+
+func TestLimitScope_WithoutTimeout(t *testing.T) {
+	db := &deadlineScope{}
+	assert.Same(t, db, limitScope(db, 0))
+
+	_ = limitScope(db, 0).GetContext(context.Background(), nil, "SELECT 1")
+	assert.False(t, db.ok)
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+var tracer = otel.Tracer("github.com/practice-sem-2/user-service/internal/storages")
+
+// tracedScope starts span for each query made with context. Only query
+// text is recorded, arguments may contain passwords and personal data
+type tracedScope struct {
+	Scope
+}
+
+func traceScope(db Scope) Scope {
+	return tracedScope{Scope: db}
+}
+
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	operation := query
+	if i := strings.IndexAny(query, " \n"); i > 0 {
+		operation = query[:i]
+	}
+	operation = strings.ToUpper(operation)
+
+	return tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperation(operation),
+			semconv.DBStatement(query),
+		),
+	)
+}
+
+func endQuerySpan(span trace.Span, err error) {
+	// No rows is an expected outcome, not a failure of the query
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s tracedScope) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := startQuerySpan(ctx, query)
+	err := s.Scope.GetContext(ctx, dest, query, args...)
+	endQuerySpan(span, err)
+	return err
+}
+
+func (s tracedScope) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := startQuerySpan(ctx, query)
+	err := s.Scope.SelectContext(ctx, dest, query, args...)
+	endQuerySpan(span, err)
+	return err
+}
+
+func (s tracedScope) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	result, err := s.Scope.ExecContext(ctx, query, args...)
+	endQuerySpan(span, err)
+	return result, err
+}
+
+func (s tracedScope) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	rows, err := s.Scope.QueryxContext(ctx, query, args...)
+	endQuerySpan(span, err)
+	return rows, err
+}
+
+// QueryRowxContext span ends before the row is scanned, so
+// it covers the query, but errors of scanning are not recorded
+func (s tracedScope) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	ctx, span := startQuerySpan(ctx, query)
+	row := s.Scope.QueryRowxContext(ctx, query, args...)
+	endQuerySpan(span, row.Err())
+	return row
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"time"
+)
+
+type TwoFactorStorage struct {
+	db              Scope
+	selectTOTP      sq.SelectBuilder
+	selectChallenge sq.SelectBuilder
+}
+
+func NewTwoFactorStorage(db Scope) TwoFactorStorage {
+	return TwoFactorStorage{
+		db:              db,
+		selectTOTP:      sq.Select("username", "secret_encrypted", "confirmed_at", "last_used_step", "created_at").From("users_totp").PlaceholderFormat(sq.Dollar),
+		selectChallenge: sq.Select("challenge_hash", "username", "issue_tokens", "attempts", "created_at", "expires_at").From("second_factor_challenges").PlaceholderFormat(sq.Dollar),
+	}
+}
+
+var (
+	ErrTOTPNotFound      = errors.New("totp is not enrolled")
+	ErrChallengeNotFound = errors.New("second factor challenge not found")
+)
+
+// GetTOTP returns TOTP enrolment of user and locks it until the end of transaction
+func (s *TwoFactorStorage) GetTOTP(ctx context.Context, username string) (*models.TOTP, error) {
+	query, args, err := s.selectTOTP.
+		Where(sq.Eq{"username": username}).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var totp models.TOTP
+	err = s.db.GetContext(ctx, &totp, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTOTPNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// SetTOTP starts new unconfirmed enrolment replacing previous one
+func (s *TwoFactorStorage) SetTOTP(ctx context.Context, username string, secretEncrypted []byte) error {
+	query, args, err := sq.Insert("users_totp").
+		Columns("username", "secret_encrypted", "confirmed_at", "last_used_step", "created_at").
+		Values(username, secretEncrypted, nil, 0, time.Now()).
+		Suffix("ON CONFLICT (username) DO UPDATE SET " +
+			"secret_encrypted = EXCLUDED.secret_encrypted, " +
+			"confirmed_at = EXCLUDED.confirmed_at, " +
+			"last_used_step = EXCLUDED.last_used_step, " +
+			"created_at = EXCLUDED.created_at").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *TwoFactorStorage) ConfirmTOTP(ctx context.Context, username string, step int64) error {
+	query, args, err := sq.Update("users_totp").
+		Set("confirmed_at", time.Now()).
+		Set("last_used_step", step).
+		Where(sq.Eq{"username": username}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *TwoFactorStorage) SetTOTPLastUsedStep(ctx context.Context, username string, step int64) error {
+	query, args, err := sq.Update("users_totp").
+		Set("last_used_step", step).
+		Where(sq.Eq{"username": username}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// DeleteTOTP disables 2FA and removes recovery codes
+func (s *TwoFactorStorage) DeleteTOTP(ctx context.Context, username string) error {
+	for _, table := range []string{"users_totp", "users_recovery_codes"} {
+		query, args, err := sq.Delete(table).
+			Where(sq.Eq{"username": username}).
+			PlaceholderFormat(sq.Dollar).
+			ToSql()
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = s.db.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes removes all recovery codes of user and stores new ones
+func (s *TwoFactorStorage) ReplaceRecoveryCodes(ctx context.Context, username string, codeHashes []string) error {
+	query, args, err := sq.Delete("users_recovery_codes").
+		Where(sq.Eq{"username": username}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.db.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	insert := sq.Insert("users_recovery_codes").Columns("username", "code_hash").PlaceholderFormat(sq.Dollar)
+	for _, hash := range codeHashes {
+		insert = insert.Values(username, hash)
+	}
+
+	query, args, err = insert.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// UseRecoveryCode marks unused code as used and reports whether there was such code
+func (s *TwoFactorStorage) UseRecoveryCode(ctx context.Context, username string, codeHash string) (bool, error) {
+	query, args, err := sq.Update("users_recovery_codes").
+		Set("used_at", time.Now()).
+		Where(sq.Eq{"username": username, "code_hash": codeHash, "used_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+
+	if err != nil {
+		return false, err
+	}
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *TwoFactorStorage) CreateChallenge(ctx context.Context, username string, challengeHash string, issueTokens bool, ttl time.Duration) (*models.SecondFactorChallenge, error) {
+	now := time.Now()
+	challenge := models.SecondFactorChallenge{
+		ChallengeHash: challengeHash,
+		Username:      username,
+		IssueTokens:   issueTokens,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
+	}
+
+	query, args, err := sq.Insert("second_factor_challenges").
+		Columns("challenge_hash", "username", "issue_tokens", "created_at", "expires_at").
+		Values(challenge.ChallengeHash, challenge.Username, challenge.IssueTokens, challenge.CreatedAt, challenge.ExpiresAt).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = s.db.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// GetChallenge finds challenge by its hash and locks it until the end of transaction
+func (s *TwoFactorStorage) GetChallenge(ctx context.Context, challengeHash string) (*models.SecondFactorChallenge, error) {
+	query, args, err := s.selectChallenge.
+		Where(sq.Eq{"challenge_hash": challengeHash}).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var challenge models.SecondFactorChallenge
+	err = s.db.GetContext(ctx, &challenge, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChallengeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+func (s *TwoFactorStorage) IncrementChallengeAttempts(ctx context.Context, challengeHash string) error {
+	query, args, err := sq.Update("second_factor_challenges").
+		Set("attempts", sq.Expr("attempts + 1")).
+		Where(sq.Eq{"challenge_hash": challengeHash}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *TwoFactorStorage) DeleteChallenge(ctx context.Context, challengeHash string) error {
+	query, args, err := sq.Delete("second_factor_challenges").
+		Where(sq.Eq{"challenge_hash": challengeHash}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
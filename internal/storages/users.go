@@ -9,7 +9,9 @@ import (
 	"github.com/jackc/pgx"
 	"github.com/practice-sem-2/user-service/internal/models"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type UserStorage struct {
@@ -22,14 +24,90 @@ type UserStorage struct {
 
 func NewUserStorage(db Scope) UserStorage {
 	return UserStorage{
-		db:         db,
-		selectUser: sq.Select("*").From("users").PlaceholderFormat(sq.Dollar),
+		db:         traceScope(db),
+		selectUser: sq.Select(userColumns...).From("users").Where(notDeleted).PlaceholderFormat(sq.Dollar),
 		insertUser: sq.Insert("users").PlaceholderFormat(sq.Dollar),
 		updateUser: sq.Update("users").PlaceholderFormat(sq.Dollar),
 		deleteUser: sq.Delete("users").PlaceholderFormat(sq.Dollar),
 	}
 }
 
+var userColumns = []string{
+	"username",
+	"email",
+	"is_active",
+	"password_hash",
+	"first_name",
+	"last_name",
+	"avatar_id",
+	"created_at",
+}
+
+// sortableUserColumns are the ones users can be listed by
+var sortableUserColumns = []string{
+	"username",
+	"email",
+	"first_name",
+	"last_name",
+	"created_at",
+}
+
+var returningUser = "RETURNING " + strings.Join(userColumns, ", ")
+
+// notDeleted excludes soft deleted users, which must look as if they don't exist
+var notDeleted = sq.Eq{"deleted_at": nil}
+
+// selectUserColumns returns select builder for provided columns only.
+// Username is always selected, because it identifies user.
+// If no columns provided, all of them are selected
+func (s *UserStorage) selectUserColumns(columns []string) (sq.SelectBuilder, error) {
+	if len(columns) == 0 {
+		return s.selectUser, nil
+	}
+
+	selected := []string{"username"}
+	for _, column := range columns {
+		if !isUserColumn(column) {
+			return sq.SelectBuilder{}, &UnknownColumnError{Column: column}
+		}
+		if column != "username" {
+			selected = append(selected, column)
+		}
+	}
+	return sq.Select(selected...).From("users").Where(notDeleted).PlaceholderFormat(sq.Dollar), nil
+}
+
+func isUserColumn(column string) bool {
+	return contains(userColumns, column)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// UnknownColumnError is returned when selected columns are not known
+type UnknownColumnError struct {
+	Column string
+}
+
+func (e *UnknownColumnError) Error() string {
+	return fmt.Sprintf("users have no column %s", e.Column)
+}
+
+// UnsortableColumnError is returned when users can't be ordered by column
+type UnsortableColumnError struct {
+	Column string
+}
+
+func (e *UnsortableColumnError) Error() string {
+	return fmt.Sprintf("users can't be ordered by %s", e.Column)
+}
+
 type MissingUsersError struct {
 	Usernames []string
 }
@@ -44,13 +122,14 @@ var (
 	ErrUserAlreadyExists  = errors.New("user with provided username already exists")
 	ErrUserNotFound       = errors.New("user not found")
 	ErrInvalidCode        = errors.New("activation code is incorrect")
+	ErrInvalidCursor      = errors.New("cursor does not match the sort column")
 )
 
 func (s *UserStorage) CreateUser(ctx context.Context, user *models.UserCreate) (*models.User, error) {
 	builder := s.insertUser.
 		Columns("username", "email", "is_active", "password_hash", "first_name", "last_name", "avatar_id").
 		Values(user.Username, user.Email, false, user.Password, user.FirstName, user.LastName, user.AvatarID).
-		Suffix("RETURNING *")
+		Suffix(returningUser)
 
 	query, args, err := builder.ToSql()
 
@@ -58,10 +137,8 @@ func (s *UserStorage) CreateUser(ctx context.Context, user *models.UserCreate) (
 		return nil, err
 	}
 
-	row := s.db.QueryRowxContext(ctx, query, args...)
 	var createdUser models.User
-
-	err = row.StructScan(&createdUser)
+	err = s.db.GetContext(ctx, &createdUser, query, args...)
 
 	if err != nil {
 		if pgErr, ok := err.(pgx.PgError); ok {
@@ -76,24 +153,30 @@ func (s *UserStorage) CreateUser(ctx context.Context, user *models.UserCreate) (
 	return &createdUser, err
 }
 
-func (s *UserStorage) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	builder := s.selectUser.Where(sq.Eq{"username": username})
-	query, args, err := builder.ToSql()
+// GetUserByUsername selects only provided columns, or all of them if none provided
+func (s *UserStorage) GetUserByUsername(ctx context.Context, username string, columns ...string) (*models.User, error) {
+	builder, err := s.selectUserColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+	query, args, err := builder.Where(sq.Eq{"username": username}).ToSql()
 
 	if err != nil {
 		return nil, err
 	}
 	var user models.User
 	err = s.db.GetContext(ctx, &user, query, args...)
-	if err == sql.ErrNoRows {
+	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrUserNotFound
 	} else {
 		return &user, err
 	}
 }
 
-func (s *UserStorage) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	builder := s.selectUser.Where(sq.Eq{"email": email})
+// GetUserForUpdate works like GetUserByUsername, but also locks user's row
+// until the end of transaction. Makes sense only inside Storage.Atomic
+func (s *UserStorage) GetUserForUpdate(ctx context.Context, username string) (*models.User, error) {
+	builder := s.selectUser.Where(sq.Eq{"username": username}).Suffix("FOR UPDATE")
 	query, args, err := builder.ToSql()
 
 	if err != nil {
@@ -101,19 +184,44 @@ func (s *UserStorage) GetUserByEmail(ctx context.Context, email string) (*models
 	}
 	var user models.User
 	err = s.db.GetContext(ctx, &user, query, args...)
-	if err == sql.ErrNoRows {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	} else {
+		return &user, err
+	}
+}
+
+// GetUserByEmail selects only provided columns, or all of them if none provided
+func (s *UserStorage) GetUserByEmail(ctx context.Context, email string, columns ...string) (*models.User, error) {
+	builder, err := s.selectUserColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+	query, args, err := builder.Where(sq.Eq{"email": email}).ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	err = s.db.GetContext(ctx, &user, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrUserNotFound
 	} else {
 		return &user, err
 	}
 }
 
-func (s *UserStorage) GetManyUsers(ctx context.Context, usernames []string) ([]models.User, error) {
+// GetManyUsers selects only provided columns, or all of them if none provided
+func (s *UserStorage) GetManyUsers(ctx context.Context, usernames []string, columns ...string) ([]models.User, error) {
+	builder, err := s.selectUserColumns(columns)
+	if err != nil {
+		return nil, err
+	}
 	q := make(sq.Or, len(usernames))
 	for i, name := range usernames {
 		q[i] = sq.Eq{"username": name}
 	}
-	query, args, err := s.selectUser.Where(q).ToSql()
+	query, args, err := builder.Where(q).ToSql()
 	if err != nil {
 		return nil, err
 	}
@@ -139,9 +247,171 @@ func (s *UserStorage) GetManyUsers(ctx context.Context, usernames []string) ([]m
 	return users, err
 }
 
+// ListUsers returns up to Limit users after the cursor. Users are ordered
+// by the sort column and then by username, which makes keyset pagination stable
+func (s *UserStorage) ListUsers(ctx context.Context, q models.ListUsersQuery) ([]models.User, error) {
+	order := q.Order.Column
+	if order == "" {
+		order = "username"
+	}
+	if !contains(sortableUserColumns, order) {
+		return nil, &UnsortableColumnError{Column: order}
+	}
+
+	columns := q.Columns
+	if len(columns) > 0 && !contains(columns, order) {
+		// Sort value is needed to build the next cursor
+		columns = append(columns[:len(columns):len(columns)], order)
+	}
+
+	builder, err := s.selectUserColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	builder = builder.Where(userFilter(q.Filter))
+
+	direction, compare := "ASC", ">"
+	if q.Order.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	if q.After != nil {
+		if order == "username" {
+			builder = builder.Where("username "+compare+" ?", q.After.Username)
+		} else {
+			var value interface{} = q.After.Value
+			if order == "created_at" {
+				if value, err = time.Parse(time.RFC3339Nano, q.After.Value); err != nil {
+					return nil, ErrInvalidCursor
+				}
+			}
+			builder = builder.Where(
+				fmt.Sprintf("(%s, username) %s (?, ?)", order, compare),
+				value, q.After.Username,
+			)
+		}
+	}
+
+	if order != "username" {
+		builder = builder.OrderBy(order + " " + direction)
+	}
+	query, args, err := builder.
+		OrderBy("username " + direction).
+		Limit(q.Limit).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]models.User, 0, q.Limit)
+	err = s.db.SelectContext(ctx, &users, query, args...)
+	return users, err
+}
+
+// searchedColumns are matched against search text, each has trigram index
+var searchedColumns = []string{
+	"username",
+	"first_name",
+	"last_name",
+	"split_part(email, '@', 1)",
+}
+
+// SearchUsers finds users whose names or email local part start with
+// or are similar to the text. Best matches go first, ties are ordered by username
+func (s *UserStorage) SearchUsers(ctx context.Context, q models.SearchUsersQuery) ([]models.UserMatch, error) {
+	builder, err := s.selectUserColumns(q.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := escapeLike(q.Text) + "%"
+	prefixMatch := make(sq.Or, len(searchedColumns))
+	similar := make(sq.Or, len(searchedColumns))
+	similarity := make([]string, len(searchedColumns))
+	similarityArgs := make([]interface{}, len(searchedColumns))
+	for i, column := range searchedColumns {
+		prefixMatch[i] = sq.Expr(column+" ILIKE ?", prefix)
+		similar[i] = sq.Expr(column+" % ?", q.Text)
+		similarity[i] = "similarity(" + column + ", ?)"
+		similarityArgs[i] = q.Text
+	}
+
+	prefixSql, prefixArgs, err := prefixMatch.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rank := sq.Expr(
+		"((CASE WHEN "+prefixSql+" THEN 1 ELSE 0 END) + GREATEST("+strings.Join(similarity, ", ")+"))::float8 AS rank",
+		append(prefixArgs, similarityArgs...)...,
+	)
+
+	inner := builder.
+		Column(rank).
+		Where(sq.Or{prefixMatch, similar}).
+		Where(userFilter(q.Filter))
+
+	// Rank is computed, so it is compared in outer query
+	outer := sq.Select("*").FromSelect(inner, "matches").PlaceholderFormat(sq.Dollar)
+	if q.After != nil {
+		after, err := strconv.ParseFloat(q.After.Value, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		outer = outer.Where(
+			"(rank < ? OR (rank = ? AND username > ?))",
+			after, after, q.After.Username,
+		)
+	}
+
+	query, args, err := outer.
+		OrderBy("rank DESC", "username ASC").
+		Limit(q.Limit).
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]models.UserMatch, 0, q.Limit)
+	err = s.db.SelectContext(ctx, &users, query, args...)
+	return users, err
+}
+
+func userFilter(f models.UserFilter) sq.And {
+	conditions := sq.And{}
+	if f.IsActive != nil {
+		conditions = append(conditions, sq.Eq{"is_active": *f.IsActive})
+	}
+	if f.EmailDomain != nil {
+		conditions = append(conditions, sq.ILike{"email": "%@" + escapeLike(*f.EmailDomain)})
+	}
+	if f.NamePrefix != nil {
+		prefix := escapeLike(*f.NamePrefix) + "%"
+		conditions = append(conditions, sq.Or{
+			sq.ILike{"username": prefix},
+			sq.ILike{"first_name": prefix},
+			sq.ILike{"last_name": prefix},
+		})
+	}
+	if f.CreatedAfter != nil {
+		conditions = append(conditions, sq.GtOrEq{"created_at": *f.CreatedAfter})
+	}
+	if f.CreatedBefore != nil {
+		conditions = append(conditions, sq.Lt{"created_at": *f.CreatedBefore})
+	}
+	return conditions
+}
+
+// escapeLike makes LIKE treat wildcards in user input literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 func (s *UserStorage) UpdateUser(ctx context.Context, username string, fields models.UpdateFields) (*models.User, error) {
 	patchList := filterNil(fields)
-	q := s.updateUser.Where(sq.Eq{"username": username}).Suffix("RETURNING *")
+	q := s.updateUser.Where(sq.Eq{"username": username}).Where(notDeleted).Suffix(returningUser)
 
 	for field, value := range patchList {
 		q = q.Set(field, value)
@@ -174,65 +444,129 @@ func (s *UserStorage) UpdateUser(ctx context.Context, username string, fields mo
 	return user, nil
 }
 
-func (s *UserStorage) ActivateUser(ctx context.Context, username string, code string) error {
-	query, args, err := sq.
-		Select("username, code").From("users").
-		LeftJoin("users_activation_codes c ON u.username = c.code").
-		Where(sq.Eq{"username": username, "code": code}).
-		Suffix("FOR UPDATE").
-		PlaceholderFormat(sq.Dollar).
+// ActivateUser only marks user as active, activation code
+// must be checked by caller within the same transaction
+func (s *UserStorage) ActivateUser(ctx context.Context, username string) error {
+	query, args, err := s.updateUser.
+		Set("is_active", true).
+		Where(sq.Eq{"username": username}).
+		Where(notDeleted).
 		ToSql()
 
-	var data []struct {
-		Username string  `db:"username"`
-		Code     *string `db:"code"`
+	if err != nil {
+		return err
 	}
 
-	err = s.db.SelectContext(ctx, &data, query, args...)
-	if errors.Is(err, sql.ErrNoRows) {
-		return ErrUserNotFound
-	} else if err != nil {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
 		return err
 	}
 
-	if data[0].Code == nil {
-		return ErrInvalidCode
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrUserNotFound
 	}
+	return nil
+}
 
-	query, args, err = sq.Update("users").
-		Set("is_active", true).
+// DeleteUser only marks user as deleted, so it can be restored during grace
+// period. Username and email stay taken until user is purged
+func (s *UserStorage) DeleteUser(ctx context.Context, username string) error {
+	query, args, err := s.updateUser.
+		Set("deleted_at", sq.Expr("now()")).
 		Where(sq.Eq{"username": username}).
+		Where(notDeleted).
 		ToSql()
 
 	if err != nil {
 		return err
 	}
 
-	if _, err = s.db.ExecContext(ctx, query, args...); err != nil {
+	res, err := s.db.ExecContext(ctx, query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
 		return err
+	} else if affected == 0 {
+		return ErrUserNotFound
 	}
-	// No need to reactivation user, so delete all activation codes
-	query, args, err = sq.Delete("users_activation_code").
-		Where(sq.Eq{"username": username}).
-		ToSql()
 
 	return nil
 }
 
-func (s *UserStorage) DeleteUser(ctx context.Context, username string) error {
-	query, args, err := s.deleteUser.Where(sq.Eq{"username": username}).ToSql()
+// GetDeletedUserForUpdate returns soft deleted user and locks it
+// until the end of transaction. Makes sense only inside Storage.Atomic
+func (s *UserStorage) GetDeletedUserForUpdate(ctx context.Context, username string) (*models.User, error) {
+	columns := append(userColumns[:len(userColumns):len(userColumns)], "deleted_at")
+	query, args, err := sq.Select(columns...).
+		From("users").
+		Where(sq.Eq{"username": username}).
+		Where(sq.NotEq{"deleted_at": nil}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
 
-	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	err = s.db.GetContext(ctx, &user, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	} else {
+		return &user, err
+	}
+}
+
+func (s *UserStorage) RestoreUser(ctx context.Context, username string) (*models.User, error) {
+	query, args, err := s.updateUser.
+		Set("deleted_at", nil).
+		Where(sq.Eq{"username": username}).
+		Where(sq.NotEq{"deleted_at": nil}).
+		Suffix(returningUser).
+		ToSql()
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if affected, err := res.RowsAffected(); err != nil && affected == 0 {
-		return ErrUserNotFound
+	var user models.User
+	err = s.db.GetContext(ctx, &user, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
 	}
+	return &user, nil
+}
 
-	return nil
+// PurgeDeletedUsers removes up to limit users deleted before provided time
+// together with everything that references them. Returns usernames of purged
+// users. Rows locked by other purgers are skipped
+func (s *UserStorage) PurgeDeletedUsers(ctx context.Context, before time.Time, limit uint64) ([]string, error) {
+	expired := sq.Select("username").
+		From("users").
+		Where(sq.Lt{"deleted_at": before}).
+		OrderBy("deleted_at").
+		Limit(limit).
+		Suffix("FOR UPDATE SKIP LOCKED")
+
+	query, args, err := s.deleteUser.
+		Where(sq.Expr("username IN (?)", expired)).
+		Suffix("RETURNING username").
+		ToSql()
+
+	if err != nil {
+		return nil, err
+	}
+
+	usernames := make([]string, 0, limit)
+	err = s.db.SelectContext(ctx, &usernames, query, args...)
+	return usernames, err
 }
 
 func filterNil(arg interface{}) map[string]interface{} {
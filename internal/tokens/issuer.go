@@ -0,0 +1,45 @@
+package token
+
+import (
+	"github.com/golang-jwt/jwt/v4"
+	"time"
+)
+
+// Issuer signs short-living access tokens with the current key of the set
+type Issuer struct {
+	keys   *KeySet
+	issuer string
+	ttl    time.Duration
+}
+
+func NewIssuer(keys *KeySet, issuer string, ttl time.Duration) *Issuer {
+	return &Issuer{
+		keys:   keys,
+		issuer: issuer,
+		ttl:    ttl,
+	}
+}
+
+// Issue returns signed access token for user with provided username
+func (i *Issuer) Issue(username string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(i.ttl)
+
+	claims := jwt.RegisteredClaims{
+		Issuer:    i.issuer,
+		Subject:   username,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+
+	key := i.keys.Current()
+	t := jwt.NewWithClaims(key.Method, claims)
+	t.Header["kid"] = key.ID
+
+	signed, err := t.SignedString(key.Private)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
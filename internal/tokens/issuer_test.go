@@ -0,0 +1,48 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func parse(t *testing.T, signed string, key *Key) *jwt.RegisteredClaims {
+	claims := &jwt.RegisteredClaims{}
+	parsed, err := jwt.ParseWithClaims(signed, claims, func(t *jwt.Token) (interface{}, error) {
+		return key.Public(), nil
+	})
+	assert.Nil(t, err)
+	assert.True(t, parsed.Valid)
+	assert.Equal(t, key.ID, parsed.Header["kid"])
+	assert.Equal(t, key.Method.Alg(), parsed.Header["alg"])
+	return claims
+}
+
+func TestIssuer_IssuesEd25519Token(t *testing.T) {
+	key, err := GenerateKey()
+	assert.Nil(t, err)
+
+	signed, expiresAt, err := NewIssuer(NewStaticKeySet(key), "user-service", time.Minute).Issue("joe")
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expiresAt, time.Second)
+
+	claims := parse(t, signed, key)
+	assert.Equal(t, "joe", claims.Subject)
+	assert.Equal(t, "user-service", claims.Issuer)
+}
+
+func TestIssuer_IssuesRS256Token(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	key, err := NewKey(private)
+	assert.Nil(t, err)
+
+	signed, _, err := NewIssuer(NewStaticKeySet(key), "user-service", time.Minute).Issue("joe")
+	assert.Nil(t, err)
+
+	claims := parse(t, signed, key)
+	assert.Equal(t, "joe", claims.Subject)
+}
@@ -0,0 +1,74 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+)
+
+// JWK is a public part of signing key as described in RFC 7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// RSA keys
+	Modulus  string `json:"n,omitempty"`
+	Exponent string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *Key) JWK() JWK {
+	jwk := JWK{
+		KeyID:     k.ID,
+		Algorithm: k.Method.Alg(),
+		Use:       "sig",
+	}
+
+	switch public := k.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.Modulus = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.Exponent = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return jwk
+}
+
+func (s *KeySet) JWKS() JWKS {
+	keys := s.Keys()
+	jwks := JWKS{Keys: make([]JWK, len(keys))}
+	for i, key := range keys {
+		jwks.Keys[i] = key.JWK()
+	}
+	return jwks
+}
+
+// NewJWKSHandler serves public keys of the set, usually
+// mounted at /.well-known/jwks.json
+func NewJWKSHandler(keys *KeySet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		// Verifiers should not cache keys for too long,
+		// otherwise they won't notice rotation
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_ = json.NewEncoder(w).Encode(keys.JWKS())
+	})
+}
@@ -0,0 +1,91 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"os"
+)
+
+var (
+	ErrNoPEMBlock         = errors.New("key file does not contain PEM block")
+	ErrUnsupportedKeyType = errors.New("only Ed25519 and RSA private keys are supported")
+)
+
+// Key is a private key used to sign tokens
+type Key struct {
+	// ID is published as "kid" header, so verifiers can pick the right public key
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// LoadKey reads PKCS#8 encoded Ed25519 or RSA private key from PEM file
+func LoadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+	return NewKey(signer)
+}
+
+// GenerateKey creates new random Ed25519 key
+func GenerateKey() (*Key, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(private)
+}
+
+func NewKey(private crypto.Signer) (*Key, error) {
+	var method jwt.SigningMethod
+	switch private.(type) {
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	// Key ID is derived from public key, so it is stable across
+	// restarts and replicas that load the same key
+	sum := sha256.Sum256(der)
+
+	return &Key{
+		ID:      base64.RawURLEncoding.EncodeToString(sum[:12]),
+		Method:  method,
+		Private: private,
+	}, nil
+}
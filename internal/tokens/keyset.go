@@ -0,0 +1,128 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyFileTimeLayout is the layout of publication time key file names start
+// with, like 20240131T120000Z.pem or 20240131T120000Z-main.pem
+const KeyFileTimeLayout = "20060102T150405Z"
+
+var ErrNoKeys = errors.New("there are no signing keys")
+
+type publishedKey struct {
+	key         *Key
+	publishedAt time.Time
+}
+
+// KeySet holds all keys that verifiers should trust and chooses the one used
+// for signing. New key is published in advance and starts signing tokens
+// only after activation delay, so verifiers have time to fetch it. Old keys
+// stay published until they are removed from the key directory.
+type KeySet struct {
+	mu    sync.RWMutex
+	keys  []publishedKey
+	dir   string
+	delay time.Duration
+}
+
+// NewStaticKeySet returns key set that always signs with provided key
+func NewStaticKeySet(key *Key) *KeySet {
+	return &KeySet{
+		keys: []publishedKey{{key: key}},
+	}
+}
+
+// LoadKeySet reads all *.pem files from the directory. Names of the files
+// start with the time when key was published in KeyFileTimeLayout. It is
+// not taken from modification time, which changes whenever files are copied
+func LoadKeySet(dir string, activationDelay time.Duration) (*KeySet, error) {
+	s := &KeySet{
+		dir:   dir,
+		delay: activationDelay,
+	}
+	return s, s.Reload()
+}
+
+// Reload rereads key directory, so keys can be added and removed without
+// restart. If reload fails, previously loaded keys are kept
+func (s *KeySet) Reload() error {
+	if s.dir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make([]publishedKey, 0, len(paths))
+	for _, path := range paths {
+		publishedAt, err := keyPublishedAt(path)
+		if err != nil {
+			return err
+		}
+
+		key, err := LoadKey(path)
+		if err != nil {
+			return fmt.Errorf("can't load key %s: %w", path, err)
+		}
+		keys = append(keys, publishedKey{key: key, publishedAt: publishedAt})
+	}
+
+	if len(keys) == 0 {
+		return ErrNoKeys
+	}
+
+	// Newest keys go first, Glob returns paths sorted, so order is stable
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].publishedAt.After(keys[j].publishedAt)
+	})
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+func keyPublishedAt(path string) (time.Time, error) {
+	name := filepath.Base(path)
+	if len(name) >= len(KeyFileTimeLayout) {
+		if publishedAt, err := time.Parse(KeyFileTimeLayout, name[:len(KeyFileTimeLayout)]); err == nil {
+			return publishedAt, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("name of key %s must start with publication time like %s", path, KeyFileTimeLayout)
+}
+
+// Current returns the newest key which has been published for at least
+// activation delay. If there is no such key, the oldest one is used
+func (s *KeySet) Current() *Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	for _, k := range s.keys {
+		if !k.publishedAt.Add(s.delay).After(now) {
+			return k.key
+		}
+	}
+	return s.keys[len(s.keys)-1].key
+}
+
+// Keys returns all published keys, newest first
+func (s *KeySet) Keys() []*Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*Key, len(s.keys))
+	for i, k := range s.keys {
+		keys[i] = k.key
+	}
+	return keys
+}
@@ -0,0 +1,92 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKey names key file after publication time, modification
+// time is set to now, as if all files were just copied
+func writeKey(t *testing.T, dir string, publishedAt time.Time) *Key {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.Nil(t, err)
+
+	path := filepath.Join(dir, publishedAt.UTC().Format(KeyFileTimeLayout)+".pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	assert.Nil(t, err)
+
+	key, err := NewKey(private)
+	assert.Nil(t, err)
+	return key
+}
+
+func TestKeySet_SignsWithNewKeyOnlyAfterActivationDelay(t *testing.T) {
+	dir := t.TempDir()
+	old := writeKey(t, dir, time.Now().Add(-48*time.Hour))
+	fresh := writeKey(t, dir, time.Now().Add(-time.Minute))
+
+	set, err := LoadKeySet(dir, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, old.ID, set.Current().ID, "Should not sign with key published recently")
+
+	jwks := set.JWKS()
+	assert.Len(t, jwks.Keys, 2, "Should publish both keys")
+	assert.Equal(t, fresh.ID, jwks.Keys[0].KeyID)
+	assert.Equal(t, old.ID, jwks.Keys[1].KeyID)
+	assert.Equal(t, "OKP", jwks.Keys[0].KeyType)
+	assert.Equal(t, "EdDSA", jwks.Keys[0].Algorithm)
+
+	set, err = LoadKeySet(dir, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, fresh.ID, set.Current().ID, "Should sign with the newest active key")
+}
+
+func TestKeySet_KeepsKeysIfReloadFails(t *testing.T) {
+	dir := t.TempDir()
+	publishedAt := time.Now().Add(-time.Hour)
+	key := writeKey(t, dir, publishedAt)
+
+	set, err := LoadKeySet(dir, 0)
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.Remove(filepath.Join(dir, publishedAt.UTC().Format(KeyFileTimeLayout)+".pem")))
+	assert.ErrorIs(t, set.Reload(), ErrNoKeys)
+	assert.Equal(t, key.ID, set.Current().ID)
+}
+
+func TestKeySet_IgnoresModificationTime(t *testing.T) {
+	dir := t.TempDir()
+	old := writeKey(t, dir, time.Now().Add(-48*time.Hour))
+	writeKey(t, dir, time.Now().Add(-time.Minute))
+
+	// All files share one modification time, like after update of mounted secret
+	now := time.Now()
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+	for _, path := range paths {
+		assert.Nil(t, os.Chtimes(path, now, now))
+	}
+
+	set, err := LoadKeySet(dir, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, old.ID, set.Current().ID)
+}
+
+func TestKeySet_RequiresPublicationTimeInName(t *testing.T) {
+	dir := t.TempDir()
+	publishedAt := time.Now().Add(-time.Hour)
+	writeKey(t, dir, publishedAt)
+	name := publishedAt.UTC().Format(KeyFileTimeLayout) + ".pem"
+	assert.Nil(t, os.Rename(filepath.Join(dir, name), filepath.Join(dir, "key.pem")))
+
+	_, err := LoadKeySet(dir, 0)
+	assert.ErrorContains(t, err, "must start with publication time")
+}
@@ -0,0 +1,52 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrInvalidToken = errors.New("access token is invalid")
+
+// Verifier checks access tokens signed by any key of the set,
+// so tokens stay valid for a while after key is rotated
+type Verifier struct {
+	keys   *KeySet
+	issuer string
+}
+
+func NewVerifier(keys *KeySet, issuer string) *Verifier {
+	return &Verifier{
+		keys:   keys,
+		issuer: issuer,
+	}
+}
+
+// Verify returns username the token was issued for
+func (v *Verifier) Verify(signed string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	parsed, err := jwt.ParseWithClaims(signed, claims, v.keyFunc)
+	if err != nil || !parsed.Valid {
+		return "", ErrInvalidToken
+	}
+
+	if !claims.VerifyIssuer(v.issuer, true) || claims.Subject == "" {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+func (v *Verifier) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	for _, key := range v.keys.Keys() {
+		if key.ID != kid {
+			continue
+		}
+		// Otherwise token could pick weaker algorithm than key is meant for
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("key %s is not used with %s", kid, t.Method.Alg())
+		}
+		return key.Public(), nil
+	}
+	return nil, fmt.Errorf("unknown key %s", kid)
+}
@@ -0,0 +1,52 @@
+package token
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestVerifier_AcceptsIssuedToken(t *testing.T) {
+	key, err := GenerateKey()
+	assert.Nil(t, err)
+	keys := NewStaticKeySet(key)
+
+	signed, _, err := NewIssuer(keys, "user-service", time.Minute).Issue("joe")
+	assert.Nil(t, err)
+
+	username, err := NewVerifier(keys, "user-service").Verify(signed)
+	assert.Nil(t, err)
+	assert.Equal(t, "joe", username)
+}
+
+func TestVerifier_RejectsExpiredToken(t *testing.T) {
+	key, err := GenerateKey()
+	assert.Nil(t, err)
+	keys := NewStaticKeySet(key)
+
+	signed, _, err := NewIssuer(keys, "user-service", -time.Minute).Issue("joe")
+	assert.Nil(t, err)
+
+	_, err = NewVerifier(keys, "user-service").Verify(signed)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestVerifier_RejectsForeignTokens(t *testing.T) {
+	key, err := GenerateKey()
+	assert.Nil(t, err)
+	other, err := GenerateKey()
+	assert.Nil(t, err)
+
+	signed, _, err := NewIssuer(NewStaticKeySet(other), "user-service", time.Minute).Issue("joe")
+	assert.Nil(t, err)
+	_, err = NewVerifier(NewStaticKeySet(key), "user-service").Verify(signed)
+	assert.ErrorIs(t, err, ErrInvalidToken, "unknown key")
+
+	signed, _, err = NewIssuer(NewStaticKeySet(key), "someone-else", time.Minute).Issue("joe")
+	assert.Nil(t, err)
+	_, err = NewVerifier(NewStaticKeySet(key), "user-service").Verify(signed)
+	assert.ErrorIs(t, err, ErrInvalidToken, "wrong issuer")
+
+	_, err = NewVerifier(NewStaticKeySet(key), "user-service").Verify("garbage")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
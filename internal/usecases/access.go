@@ -0,0 +1,77 @@
+package usecase
+
+import (
+	"context"
+	"github.com/practice-sem-2/user-service/internal/models"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+)
+
+// AccessUseCase tells what callers are allowed to do
+type AccessUseCase struct {
+	store *storage.Storage
+	// serviceRoles are roles of services by their client certificate identity
+	serviceRoles map[string][]string
+}
+
+func NewAccessUseCase(store *storage.Storage, serviceRoles map[string][]string) *AccessUseCase {
+	return &AccessUseCase{
+		store:        store,
+		serviceRoles: serviceRoles,
+	}
+}
+
+// Permissions returns set of permissions held by principal
+func (a *AccessUseCase) Permissions(ctx context.Context, principal models.Principal) (map[string]struct{}, error) {
+	var permissions []string
+	var err error
+	if principal.Service != "" {
+		roles := a.serviceRoles[principal.Service]
+		if len(roles) == 0 {
+			return map[string]struct{}{}, nil
+		}
+		permissions, err = a.store.GetRolePermissions(ctx, roles)
+	} else {
+		permissions, err = a.store.GetUserPermissions(ctx, principal.Username)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]struct{}, len(permissions))
+	for _, permission := range permissions {
+		set[permission] = struct{}{}
+	}
+	return set, nil
+}
+
+// GetUserRoles returns explicitly assigned roles, without the implicit one
+func (a *AccessUseCase) GetUserRoles(ctx context.Context, username string) ([]models.UserRole, error) {
+	if _, err := a.store.GetUserByUsername(ctx, username, "username"); err != nil {
+		return nil, err
+	}
+	return a.store.GetUserRoles(ctx, username)
+}
+
+func (a *AccessUseCase) AssignRole(ctx context.Context, username string, role string) error {
+	return a.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		// Deleted users still have their rows, so foreign key is not enough
+		if _, err := store.GetUserForUpdate(ctx, username); err != nil {
+			return err
+		}
+
+		if err := store.AssignRole(ctx, username, role); err != nil {
+			return err
+		}
+		return addAuditEvent(ctx, store, models.AuditRoleAssigned, username, fieldChange("role", nil, &role))
+	})
+}
+
+func (a *AccessUseCase) RevokeRole(ctx context.Context, username string, role string) error {
+	return a.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		if err := store.RevokeRole(ctx, username, role); err != nil {
+			return err
+		}
+		return addAuditEvent(ctx, store, models.AuditRoleRevoked, username, fieldChange("role", &role, nil))
+	})
+}
@@ -0,0 +1,210 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/practice-sem-2/user-service/internal/models"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"strconv"
+	"time"
+)
+
+const (
+	// AnonymousActor is recorded for calls made without credentials
+	AnonymousActor = "anonymous"
+	// SystemActor is recorded for changes made by the service itself
+	SystemActor = "system"
+)
+
+// RequestInfo describes the request that makes changes, so they can be audited
+type RequestInfo struct {
+	// Actor is empty for anonymous callers
+	Actor       string
+	RequestID   string
+	PeerAddress string
+}
+
+type requestInfoKey struct{}
+
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// requestInfo returns info of the request or of
+// the service itself if context has none
+func requestInfo(ctx context.Context) RequestInfo {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	if !ok {
+		return RequestInfo{Actor: SystemActor}
+	}
+	if info.Actor == "" {
+		info.Actor = AnonymousActor
+	}
+	return info
+}
+
+// addAuditEvent must be called inside the transaction that makes changes
+func addAuditEvent(ctx context.Context, store *storage.Storage, action string, username string, changes ...models.FieldChange) error {
+	if changes == nil {
+		changes = []models.FieldChange{}
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	info := requestInfo(ctx)
+	return store.AddAuditEvent(ctx, &models.AuditEvent{
+		Actor:       info.Actor,
+		Username:    username,
+		Action:      action,
+		Changes:     data,
+		RequestID:   info.RequestID,
+		PeerAddress: info.PeerAddress,
+	})
+}
+
+func fieldChange(field string, old *string, new *string) models.FieldChange {
+	return models.FieldChange{Field: field, Old: old, New: new}
+}
+
+// secretChange hides both values. Old is unset if field is set for the first time
+func secretChange(field string, set bool) models.FieldChange {
+	redacted := models.Redacted
+	change := models.FieldChange{Field: field, New: &redacted}
+	if !set {
+		change.Old = &redacted
+	}
+	return change
+}
+
+func stringValue(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func boolValue(b bool) *string {
+	s := strconv.FormatBool(b)
+	return &s
+}
+
+// diffUsers returns fields that differ, old is nil for a created user
+func diffUsers(old *models.User, new *models.User) []models.FieldChange {
+	if old == nil {
+		old = &models.User{}
+	}
+
+	var changes []models.FieldChange
+	addString := func(field string, before, after *string) {
+		if (before == nil) != (after == nil) || before != nil && *before != *after {
+			changes = append(changes, fieldChange(field, before, after))
+		}
+	}
+
+	addString("username", stringValue(old.Username), stringValue(new.Username))
+	addString("email", stringValue(old.Email), stringValue(new.Email))
+	addString("first_name", stringValue(old.FirstName), stringValue(new.FirstName))
+	addString("last_name", stringValue(old.LastName), stringValue(new.LastName))
+	addString("avatar_id", old.AvatarID, new.AvatarID)
+	if old.PasswordHash != new.PasswordHash {
+		changes = append(changes, secretChange("password", old.PasswordHash == ""))
+	}
+	if old.IsActive != new.IsActive {
+		changes = append(changes, fieldChange("is_active", boolValue(old.IsActive), boolValue(new.IsActive)))
+	}
+	return changes
+}
+
+type AuditUseCase struct {
+	store *storage.Storage
+}
+
+func NewAuditUseCase(store *storage.Storage) *AuditUseCase {
+	return &AuditUseCase{store: store}
+}
+
+// auditPageToken keeps filters, so a token can't be used with a different request
+type auditPageToken struct {
+	Username string     `json:"u,omitempty"`
+	Actor    string     `json:"a,omitempty"`
+	Action   string     `json:"c,omitempty"`
+	Since    *time.Time `json:"s,omitempty"`
+	Until    *time.Time `json:"e,omitempty"`
+	BeforeID int64      `json:"id"`
+}
+
+func encodeAuditPageToken(query models.AuditQuery, beforeID int64) (string, error) {
+	data, err := json.Marshal(auditPageToken{
+		Username: query.Username,
+		Actor:    query.Actor,
+		Action:   query.Action,
+		Since:    query.Since,
+		Until:    query.Until,
+		BeforeID: beforeID,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeAuditPageToken(query models.AuditQuery, token string) (int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, ErrInvalidPageToken
+	}
+
+	var t auditPageToken
+	if err = json.Unmarshal(data, &t); err != nil {
+		return 0, ErrInvalidPageToken
+	}
+
+	if t.Username != query.Username || t.Actor != query.Actor || t.Action != query.Action ||
+		!sameTime(t.Since, query.Since) || !sameTime(t.Until, query.Until) || t.BeforeID <= 0 {
+		return 0, ErrInvalidPageToken
+	}
+	return t.BeforeID, nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// List returns a page of audit events, the newest first, and token
+// of the next page, which is empty if there are no more events
+func (a *AuditUseCase) List(ctx context.Context, query models.AuditQuery, token string) ([]models.AuditEvent, string, error) {
+	query.Limit = pageSize(query.Limit)
+
+	if token != "" {
+		beforeID, err := decodeAuditPageToken(query, token)
+		if err != nil {
+			return nil, "", err
+		}
+		query.BeforeID = beforeID
+	}
+
+	size := query.Limit
+	// One more event tells whether there is the next page
+	query.Limit++
+	events, err := a.store.ListAuditEvents(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if uint64(len(events)) <= size {
+		return events, "", nil
+	}
+
+	events = events[:size]
+	next, err := encodeAuditPageToken(query, events[size-1].ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return events, next, nil
+}
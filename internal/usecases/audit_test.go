@@ -0,0 +1,89 @@
+package usecase
+
+import (
+	"context"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDiffUsers_Created(t *testing.T) {
+	avatar := "0b3a4c2e-6f7a-4a43-9d5c-0c6f9c1f0f7e"
+	user := &models.User{
+		Username:     "joe",
+		PasswordHash: "hash",
+		Email:        "joe@example.com",
+		AvatarID:     &avatar,
+	}
+
+	changes := diffUsers(nil, user)
+
+	fields := make(map[string]models.FieldChange)
+	for _, change := range changes {
+		assert.Nil(t, change.Old, change.Field)
+		fields[change.Field] = change
+	}
+	assert.Len(t, fields, 4)
+	assert.Equal(t, "joe@example.com", *fields["email"].New)
+	assert.Equal(t, avatar, *fields["avatar_id"].New)
+	assert.Equal(t, models.Redacted, *fields["password"].New)
+}
+
+func TestDiffUsers_OnlyChangedFields(t *testing.T) {
+	old := &models.User{Username: "joe", PasswordHash: "old", Email: "joe@example.com", FirstName: "Joe"}
+	updated := *old
+	updated.PasswordHash = "new"
+	updated.FirstName = ""
+
+	changes := diffUsers(old, &updated)
+
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, "first_name", changes[0].Field)
+		assert.Equal(t, "Joe", *changes[0].Old)
+		assert.Nil(t, changes[0].New)
+
+		assert.Equal(t, "password", changes[1].Field)
+		assert.Equal(t, models.Redacted, *changes[1].Old)
+		assert.Equal(t, models.Redacted, *changes[1].New)
+	}
+}
+
+func TestRequestInfo_Actor(t *testing.T) {
+	assert.Equal(t, SystemActor, requestInfo(context.Background()).Actor)
+
+	ctx := WithRequestInfo(context.Background(), RequestInfo{PeerAddress: "10.0.0.1"})
+	assert.Equal(t, AnonymousActor, requestInfo(ctx).Actor)
+
+	ctx = WithRequestInfo(context.Background(), RequestInfo{Actor: "user:joe"})
+	assert.Equal(t, "user:joe", requestInfo(ctx).Actor)
+}
+
+func TestAuditPageToken_RoundTrip(t *testing.T) {
+	since := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	query := models.AuditQuery{Username: "joe", Since: &since}
+
+	token, err := encodeAuditPageToken(query, 42)
+	assert.NoError(t, err)
+
+	beforeID, err := decodeAuditPageToken(query, token)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), beforeID)
+}
+
+func TestAuditPageToken_RejectsDifferentFilters(t *testing.T) {
+	since := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	query := models.AuditQuery{Username: "joe", Since: &since}
+
+	token, err := encodeAuditPageToken(query, 42)
+	assert.NoError(t, err)
+
+	_, err = decodeAuditPageToken(models.AuditQuery{Username: "joe"}, token)
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+	_, err = decodeAuditPageToken(models.AuditQuery{Username: "ann", Since: &since}, token)
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+	_, err = decodeAuditPageToken(query, "not a token")
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+}
@@ -0,0 +1,48 @@
+package usecase
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+const (
+	activationCodeDigits = 6
+	tokenBytes           = 32
+)
+
+// generateCode returns cryptographically random numeric code
+// of provided length padded with leading zeros
+func generateCode(digits int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*s", digits, n.String()), nil
+}
+
+// generateToken returns cryptographically random url-safe token
+func generateToken() (string, error) {
+	buf := make([]byte, tokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashCode returns keyed hash of the code. Codes are short, so plain
+// hash could be easily brute forced if database leaks
+func hashCode(secret string, code string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyCode(secret string, code string, hash string) bool {
+	return hmac.Equal([]byte(hashCode(secret, code)), []byte(hash))
+}
@@ -0,0 +1,26 @@
+package usecase
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGenerateCode_ReturnsDigitsOfRequestedLength(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := generateCode(6)
+		assert.Nil(t, err)
+		assert.Len(t, code, 6)
+		for _, c := range code {
+			assert.True(t, c >= '0' && c <= '9', "Should contain only digits, got %s", code)
+		}
+	}
+}
+
+func TestVerifyCode_ChecksCodeAgainstKeyedHash(t *testing.T) {
+	hash := hashCode("secret", "123456")
+
+	assert.NotEqual(t, "123456", hash, "Should not store code as is")
+	assert.True(t, verifyCode("secret", "123456", hash))
+	assert.False(t, verifyCode("secret", "654321", hash), "Should reject another code")
+	assert.False(t, verifyCode("another", "123456", hash), "Should reject hash made with another key")
+}
@@ -0,0 +1,18 @@
+package usecase
+
+// Counters count business events for monitoring. They are
+// incremented only after changes are committed
+type Counters interface {
+	Signup()
+	Activation()
+	FailedLogin()
+	Delete()
+}
+
+// nopCounters is used if no counters are provided
+type nopCounters struct{}
+
+func (nopCounters) Signup()      {}
+func (nopCounters) Activation()  {}
+func (nopCounters) FailedLogin() {}
+func (nopCounters) Delete()      {}
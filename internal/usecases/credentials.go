@@ -0,0 +1,179 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/user-service/internal/models"
+	notifier "github.com/practice-sem-2/user-service/internal/notifiers"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"time"
+)
+
+// checkPassword returns user only if provided password is correct.
+// Failed attempts are throttled per account and per client address
+func (u *UserUseCase) checkPassword(ctx context.Context, username string, password string, clientIP string) (*models.User, error) {
+	if err := u.reserveLoginAttempt(ctx, username, clientIP); err != nil {
+		return nil, err
+	}
+
+	user, err := u.store.GetUserByUsername(ctx, username)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		// Response must take as long as for existing user
+		u.verifyDummyHash(password)
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	ok, err := u.hasher.Verify(password, user.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrWrongPassword
+	}
+
+	if err = u.releaseLoginAttempt(ctx, username, clientIP); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// verifyDummyHash verifies password against hash of the current algorithm
+// and parameters, which is made once
+func (u *UserUseCase) verifyDummyHash(password string) {
+	u.dummyHashOnce.Do(func() {
+		u.dummyHash, _ = u.hasher.Hash("dummy password")
+	})
+	_, _ = u.hasher.Verify(password, u.dummyHash)
+}
+
+// ChangePassword sets new password if the current one is correct.
+// Password reset tokens and sessions started before are revoked
+func (u *UserUseCase) ChangePassword(ctx context.Context, username string, current string, password string, clientIP string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.ChangePassword")
+	defer func() { endSpan(span, err) }()
+
+	if _, err := u.checkPassword(ctx, username, current, clientIP); err != nil {
+		return err
+	}
+
+	hash, err := u.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	return u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		_, err := store.UpdateUser(ctx, username, models.UpdateFields{Password: &hash})
+		if err != nil {
+			return err
+		}
+		if err = store.RevokeUserRefreshTokens(ctx, username); err != nil {
+			return err
+		}
+		if err = store.DeletePasswordResetTokens(ctx, username); err != nil {
+			return err
+		}
+		return addAuditEvent(ctx, store, models.AuditPasswordChanged, username, secretChange("password", false))
+	})
+}
+
+// ChangeEmail does not change email immediately. New email is kept pending
+// until user confirms it with the code sent to the new address
+func (u *UserUseCase) ChangeEmail(ctx context.Context, username string, password string, email string, clientIP string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.ChangeEmail")
+	defer func() { endSpan(span, err) }()
+
+	user, err := u.checkPassword(ctx, username, password, clientIP)
+	if err != nil {
+		return err
+	}
+
+	// Unique constraint is checked once again on confirmation,
+	// this check is only to fail early
+	_, err = u.store.GetUserByEmail(ctx, email)
+	if err == nil {
+		return storage.ErrEmailAlreadyExists
+	} else if !errors.Is(err, storage.ErrUserNotFound) {
+		return err
+	}
+
+	code, err := generateCode(activationCodeDigits)
+	if err != nil {
+		return err
+	}
+
+	err = u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		err := store.SetPendingEmailChange(ctx, username, email, hashCode(u.config.CodeSecret, code), u.config.EmailChangeTTL)
+		if err != nil {
+			return err
+		}
+
+		change := fieldChange("pending_email", nil, &email)
+		return addAuditEvent(ctx, store, models.AuditEmailChangeRequested, username, change)
+	})
+
+	if err != nil {
+		return err
+	}
+	// Code is sent only after it is committed, so that it certainly exists
+	return u.notifier.Notify(ctx, notifier.Notification{
+		Kind:     notifier.KindEmailChange,
+		Username: user.Username,
+		Email:    email,
+		Code:     code,
+	})
+}
+
+// ConfirmEmailChange swaps user's email to the pending one
+func (u *UserUseCase) ConfirmEmailChange(ctx context.Context, username string, code string) (_ *models.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.ConfirmEmailChange")
+	defer func() { endSpan(span, err) }()
+
+	var user *models.User
+	// Same as for activation, failed attempt must be committed
+	var verifyErr error
+	err = u.store.Atomic(ctx, func(store *storage.Storage) error {
+		change, err := store.GetPendingEmailChange(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		if change.Attempts >= u.config.EmailChangeMaxAttempts {
+			return ErrEmailChangeExhausted
+		}
+
+		if time.Now().After(change.ExpiresAt) {
+			return ErrEmailChangeExpired
+		}
+
+		if !verifyCode(u.config.CodeSecret, code, change.CodeHash) {
+			verifyErr = ErrInvalidEmailCode
+			return store.IncrementEmailChangeAttempts(ctx, username)
+		}
+
+		old, err := store.GetUserForUpdate(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		user, err = store.UpdateUser(ctx, username, models.UpdateFields{Email: &change.NewEmail})
+		if err != nil {
+			return err
+		}
+
+		if err = addAuditEvent(ctx, store, models.AuditEmailChanged, username, diffUsers(old, user)...); err != nil {
+			return err
+		}
+
+		if err = addUserEvent(ctx, store, models.EventUserUpdated, username, user); err != nil {
+			return err
+		}
+		return store.DeletePendingEmailChange(ctx, username)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return user, verifyErr
+}
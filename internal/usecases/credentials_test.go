@@ -0,0 +1,37 @@
+package usecase
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// countingHasher accepts any password and counts calls
+type countingHasher struct {
+	hashes   int
+	verified []string
+}
+
+func (h *countingHasher) Hash(password string) (string, error) {
+	h.hashes++
+	return "hash of " + password, nil
+}
+
+func (h *countingHasher) Verify(password string, encoded string) (bool, error) {
+	h.verified = append(h.verified, encoded)
+	return true, nil
+}
+
+func (h *countingHasher) NeedsRehash(encoded string) bool {
+	return false
+}
+
+func TestUserUseCase_VerifiesDummyHash(t *testing.T) {
+	h := &countingHasher{}
+	u := NewUserUseCase(nil, h, nil, nil, nil, DefaultConfig)
+
+	u.verifyDummyHash("secret")
+	u.verifyDummyHash("other")
+
+	assert.Equal(t, 1, h.hashes, "Should hash once")
+	assert.Equal(t, []string{"hash of dummy password", "hash of dummy password"}, h.verified)
+}
@@ -0,0 +1,153 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+)
+
+var (
+	ErrInvalidPageToken = errors.New("page token is invalid")
+	ErrEmptySearchQuery = errors.New("search query is empty")
+)
+
+// searchOrder is how search results are always sorted
+var searchOrder = models.UserOrder{Column: "rank", Desc: true}
+
+// pageToken is opaque for clients. Order and search text are kept
+// in it, so a token can't be used with a different request
+type pageToken struct {
+	Column   string `json:"c"`
+	Desc     bool   `json:"d,omitempty"`
+	Text     string `json:"t,omitempty"`
+	Value    string `json:"v,omitempty"`
+	Username string `json:"u"`
+}
+
+func encodePageToken(order models.UserOrder, text string, cursor models.UserCursor) (string, error) {
+	data, err := json.Marshal(pageToken{
+		Column:   order.Column,
+		Desc:     order.Desc,
+		Text:     text,
+		Value:    cursor.Value,
+		Username: cursor.Username,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodePageToken(order models.UserOrder, text string, token string) (*models.UserCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	var t pageToken
+	if err = json.Unmarshal(data, &t); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	if t.Column != order.Column || t.Desc != order.Desc || t.Text != text {
+		return nil, ErrInvalidPageToken
+	}
+	return &models.UserCursor{Value: t.Value, Username: t.Username}, nil
+}
+
+func pageSize(requested uint64) uint64 {
+	if requested == 0 {
+		return DefaultPageSize
+	} else if requested > MaxPageSize {
+		return MaxPageSize
+	}
+	return requested
+}
+
+// List returns a page of users and token of the next page,
+// which is empty if there are no more users
+func (u *UserUseCase) List(ctx context.Context, query models.ListUsersQuery, token string) (_ []models.User, _ string, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.List")
+	defer func() { endSpan(span, err) }()
+
+	if query.Order.Column == "" {
+		query.Order.Column = "username"
+	}
+
+	query.Limit = pageSize(query.Limit)
+
+	if token != "" {
+		cursor, err := decodePageToken(query.Order, "", token)
+		if err != nil {
+			return nil, "", err
+		}
+		query.After = cursor
+	}
+
+	size := query.Limit
+	// One more user tells whether there is the next page
+	query.Limit++
+	users, err := u.store.ListUsers(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if uint64(len(users)) <= size {
+		return users, "", nil
+	}
+
+	users = users[:size]
+	next, err := encodePageToken(query.Order, "", users[size-1].Cursor(query.Order.Column))
+	if err != nil {
+		return nil, "", err
+	}
+	return users, next, nil
+}
+
+// Search returns a page of users matching text, best matches first.
+// Pagination works the same way as in List
+func (u *UserUseCase) Search(ctx context.Context, query models.SearchUsersQuery, token string) (_ []models.UserMatch, _ string, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.Search")
+	defer func() { endSpan(span, err) }()
+
+	query.Text = strings.TrimSpace(query.Text)
+	if query.Text == "" {
+		return nil, "", ErrEmptySearchQuery
+	}
+
+	query.Limit = pageSize(query.Limit)
+
+	if token != "" {
+		cursor, err := decodePageToken(searchOrder, query.Text, token)
+		if err != nil {
+			return nil, "", err
+		}
+		query.After = cursor
+	}
+
+	size := query.Limit
+	query.Limit++
+	matches, err := u.store.SearchUsers(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if uint64(len(matches)) <= size {
+		return matches, "", nil
+	}
+
+	matches = matches[:size]
+	next, err := encodePageToken(searchOrder, query.Text, matches[size-1].Cursor())
+	if err != nil {
+		return nil, "", err
+	}
+	return matches, next, nil
+}
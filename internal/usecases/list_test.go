@@ -0,0 +1,44 @@
+package usecase
+
+import (
+	"github.com/practice-sem-2/user-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPageToken_RoundTrip(t *testing.T) {
+	order := models.UserOrder{Column: "created_at", Desc: true}
+	cursor := models.UserCursor{Value: "2023-03-01T10:00:00Z", Username: "joe"}
+
+	token, err := encodePageToken(order, "", cursor)
+	assert.NoError(t, err)
+
+	decoded, err := decodePageToken(order, "", token)
+	if assert.NoError(t, err) {
+		assert.Equal(t, cursor, *decoded)
+	}
+}
+
+func TestPageToken_RejectsDifferentOrder(t *testing.T) {
+	token, err := encodePageToken(models.UserOrder{Column: "email"}, "", models.UserCursor{Value: "joe@example.com", Username: "joe"})
+	assert.NoError(t, err)
+
+	_, err = decodePageToken(models.UserOrder{Column: "email", Desc: true}, "", token)
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+	_, err = decodePageToken(models.UserOrder{Column: "username"}, "", token)
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+}
+
+func TestPageToken_RejectsGarbage(t *testing.T) {
+	_, err := decodePageToken(models.UserOrder{Column: "username"}, "", "not a token")
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+}
+
+func TestPageToken_RejectsDifferentSearchText(t *testing.T) {
+	token, err := encodePageToken(searchOrder, "jo", models.UserCursor{Value: "1.25", Username: "joe"})
+	assert.NoError(t, err)
+
+	_, err = decodePageToken(searchOrder, "jon", token)
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+}
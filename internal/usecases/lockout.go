@@ -0,0 +1,222 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"github.com/practice-sem-2/user-service/internal/models"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"time"
+)
+
+const (
+	attemptsByUser = "user"
+	attemptsByIP   = "ip"
+	// attemptsByTOTP counts failed second factor codes of the account.
+	// It is not reset by correct password, which anyone guessing codes has
+	attemptsByTOTP = "totp"
+)
+
+type LockoutConfig struct {
+	// FreeAttempts is how many failures are allowed without any delay
+	FreeAttempts int
+	// Every next failure doubles delay starting from BaseDelay up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// After that many failures subject is locked for LockDuration
+	AccountLockThreshold      int
+	IPLockThreshold           int
+	SecondFactorLockThreshold int
+	LockDuration              time.Duration
+	// Failures older than Window are forgotten
+	Window time.Duration
+}
+
+var DefaultLockoutConfig = LockoutConfig{
+	FreeAttempts:              3,
+	BaseDelay:                 time.Second,
+	MaxDelay:                  time.Minute,
+	AccountLockThreshold:      10,
+	IPLockThreshold:           50,
+	SecondFactorLockThreshold: 10,
+	LockDuration:              15 * time.Minute,
+	Window:                    time.Hour,
+}
+
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+	// Locked is set when threshold is reached, otherwise it is just a backoff
+	Locked bool
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter)
+}
+
+// blockedUntil returns time before which subject is not allowed to try again
+func (c LockoutConfig) blockedUntil(a *models.LoginAttempts, now time.Time) (time.Time, bool) {
+	if a.LockedUntil != nil && now.Before(*a.LockedUntil) {
+		return *a.LockedUntil, true
+	}
+
+	if a.Failures <= c.FreeAttempts || now.Sub(a.LastFailureAt) > c.Window {
+		return time.Time{}, false
+	}
+
+	delay := c.MaxDelay
+	// Prevent overflow, delay is capped anyway
+	if shift := a.Failures - c.FreeAttempts - 1; shift < 32 {
+		delay = c.BaseDelay << shift
+	}
+	if delay > c.MaxDelay {
+		delay = c.MaxDelay
+	}
+	return a.LastFailureAt.Add(delay), false
+}
+
+// withFailure returns counters after one more failed attempt
+func (c LockoutConfig) withFailure(a *models.LoginAttempts, threshold int, now time.Time) *models.LoginAttempts {
+	next := *a
+	if now.Sub(a.LastFailureAt) > c.Window || (a.LockedUntil != nil && !now.Before(*a.LockedUntil)) {
+		// Start over after window or lock has passed
+		next.Failures = 0
+		next.LockedUntil = nil
+	}
+
+	next.Failures++
+	next.LastFailureAt = now
+	if next.Failures >= threshold {
+		lockedUntil := now.Add(c.LockDuration)
+		next.LockedUntil = &lockedUntil
+	}
+	return &next
+}
+
+// withoutFailure returns counters without one failed attempt, lock which
+// it could cause is lifted as well
+func (c LockoutConfig) withoutFailure(a *models.LoginAttempts, threshold int) *models.LoginAttempts {
+	next := *a
+	if next.Failures > 0 {
+		next.Failures--
+	}
+	if next.Failures < threshold {
+		next.LockedUntil = nil
+	}
+	return &next
+}
+
+type attemptSubject struct {
+	kind      string
+	subject   string
+	threshold int
+}
+
+// loginSubjects are always in the same order, so that
+// concurrent transactions lock their counters without deadlocks
+func (u *UserUseCase) loginSubjects(username string, clientIP string) []attemptSubject {
+	subjects := []attemptSubject{{attemptsByUser, username, u.config.Lockout.AccountLockThreshold}}
+	if clientIP != "" {
+		subjects = append(subjects, attemptSubject{attemptsByIP, clientIP, u.config.Lockout.IPLockThreshold})
+	}
+	return subjects
+}
+
+// reserveLoginAttempt returns TooManyAttemptsError if either account or
+// client address has to wait before next attempt, otherwise attempt is
+// counted as failed before password is verified. Counters are locked, so
+// concurrent attempts can't get past the threshold. Failures are counted
+// even for usernames that do not exist, otherwise lockout would reveal
+// which accounts exist
+func (u *UserUseCase) reserveLoginAttempt(ctx context.Context, username string, clientIP string) error {
+	subjects := u.loginSubjects(username, clientIP)
+
+	return u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		now := time.Now()
+		attempts := make([]*models.LoginAttempts, len(subjects))
+		var retryErr *TooManyAttemptsError
+		for i, s := range subjects {
+			var err error
+			if attempts[i], err = store.GetLoginAttempts(ctx, s.kind, s.subject, true); err != nil {
+				return err
+			}
+
+			until, locked := u.config.Lockout.blockedUntil(attempts[i], now)
+			if retryAfter := until.Sub(now); retryAfter > 0 {
+				if retryErr == nil || retryAfter > retryErr.RetryAfter {
+					retryErr = &TooManyAttemptsError{RetryAfter: retryAfter, Locked: locked}
+				}
+			}
+		}
+
+		if retryErr != nil {
+			return retryErr
+		}
+
+		for i, s := range subjects {
+			if err := store.SaveLoginAttempts(ctx, u.config.Lockout.withFailure(attempts[i], s.threshold, now)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// releaseLoginAttempt takes back attempt reserved for correct password.
+// Failures of the account are forgotten, but not of the address, otherwise
+// attacker could reset them with own account
+func (u *UserUseCase) releaseLoginAttempt(ctx context.Context, username string, clientIP string) error {
+	return u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		if err := store.ResetLoginAttempts(ctx, attemptsByUser, username); err != nil {
+			return err
+		}
+		if clientIP == "" {
+			return nil
+		}
+
+		attempts, err := store.GetLoginAttempts(ctx, attemptsByIP, clientIP, true)
+		if err != nil {
+			return err
+		}
+		return store.SaveLoginAttempts(ctx, u.config.Lockout.withoutFailure(attempts, u.config.Lockout.IPLockThreshold))
+	})
+}
+
+// checkSecondFactorAllowed returns failed second factor attempts of the
+// account or TooManyAttemptsError if it has to wait. Counter is locked,
+// so concurrent attempts can't get past the threshold
+func (u *UserUseCase) checkSecondFactorAllowed(ctx context.Context, store *storage.Storage, username string) (*models.LoginAttempts, error) {
+	attempts, err := store.GetLoginAttempts(ctx, attemptsByTOTP, username, true)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	until, locked := u.config.Lockout.blockedUntil(attempts, now)
+	if retryAfter := until.Sub(now); retryAfter > 0 {
+		return nil, &TooManyAttemptsError{RetryAfter: retryAfter, Locked: locked}
+	}
+	return attempts, nil
+}
+
+func (u *UserUseCase) recordSecondFactorFailure(ctx context.Context, store *storage.Storage, attempts *models.LoginAttempts) error {
+	threshold := u.config.Lockout.SecondFactorLockThreshold
+	return store.SaveLoginAttempts(ctx, u.config.Lockout.withFailure(attempts, threshold, time.Now()))
+}
+
+// Unlock forgets failed login attempts of the user, so it can log in immediately
+func (u *UserUseCase) Unlock(ctx context.Context, username string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.Unlock")
+	defer func() { endSpan(span, err) }()
+
+	return u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		if _, err := store.GetUserByUsername(ctx, username, "username"); err != nil {
+			return err
+		}
+
+		for _, kind := range []string{attemptsByUser, attemptsByTOTP} {
+			if err := store.ResetLoginAttempts(ctx, kind, username); err != nil {
+				return err
+			}
+		}
+		return addAuditEvent(ctx, store, models.AuditUserUnlocked, username)
+	})
+}
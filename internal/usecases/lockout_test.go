@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"github.com/practice-sem-2/user-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLockoutConfig_AllowsFreeAttemptsWithoutDelay(t *testing.T) {
+	c := DefaultLockoutConfig
+	now := time.Now()
+	a := &models.LoginAttempts{Failures: c.FreeAttempts, LastFailureAt: now}
+
+	until, locked := c.blockedUntil(a, now)
+	assert.False(t, locked)
+	assert.True(t, until.IsZero(), "Should not delay free attempts")
+}
+
+func TestLockoutConfig_DelaysExponentially(t *testing.T) {
+	c := DefaultLockoutConfig
+	now := time.Now()
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for i, delay := range expected {
+		a := &models.LoginAttempts{Failures: c.FreeAttempts + i + 1, LastFailureAt: now}
+		until, locked := c.blockedUntil(a, now)
+		assert.False(t, locked)
+		assert.Equal(t, delay, until.Sub(now))
+	}
+
+	a := &models.LoginAttempts{Failures: 1000, LastFailureAt: now}
+	until, _ := c.blockedUntil(a, now)
+	assert.Equal(t, c.MaxDelay, until.Sub(now), "Should cap delay")
+}
+
+func TestLockoutConfig_LocksAfterThreshold(t *testing.T) {
+	c := DefaultLockoutConfig
+	now := time.Now()
+	a := &models.LoginAttempts{Failures: c.AccountLockThreshold - 2, LastFailureAt: now}
+
+	a = c.withFailure(a, c.AccountLockThreshold, now)
+	assert.Nil(t, a.LockedUntil, "Should not lock before threshold")
+
+	a = c.withFailure(a, c.AccountLockThreshold, now)
+	assert.NotNil(t, a.LockedUntil, "Should lock at threshold")
+
+	until, locked := c.blockedUntil(a, now)
+	assert.True(t, locked)
+	assert.Equal(t, c.LockDuration, until.Sub(now))
+}
+
+func TestLockoutConfig_ForgetsOldFailures(t *testing.T) {
+	c := DefaultLockoutConfig
+	now := time.Now()
+	lockedUntil := now.Add(-time.Second)
+	a := &models.LoginAttempts{
+		Failures:      c.AccountLockThreshold,
+		LastFailureAt: now.Add(-c.Window - time.Minute),
+		LockedUntil:   &lockedUntil,
+	}
+
+	until, locked := c.blockedUntil(a, now)
+	assert.False(t, locked)
+	assert.True(t, until.IsZero(), "Should not block after window has passed")
+
+	a = c.withFailure(a, c.AccountLockThreshold, now)
+	assert.Equal(t, 1, a.Failures, "Should start counting over")
+	assert.Nil(t, a.LockedUntil)
+}
+
+func TestLockoutConfig_WithoutFailureLiftsItsLock(t *testing.T) {
+	c := DefaultLockoutConfig
+	now := time.Now()
+	a := &models.LoginAttempts{Failures: c.IPLockThreshold - 1, LastFailureAt: now}
+
+	reserved := c.withFailure(a, c.IPLockThreshold, now)
+	assert.NotNil(t, reserved.LockedUntil)
+
+	released := c.withoutFailure(reserved, c.IPLockThreshold)
+	assert.Equal(t, a.Failures, released.Failures)
+	assert.Nil(t, released.LockedUntil, "Should lift lock caused by released attempt")
+
+	released = c.withoutFailure(&models.LoginAttempts{}, c.IPLockThreshold)
+	assert.Zero(t, released.Failures)
+}
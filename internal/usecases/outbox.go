@@ -0,0 +1,98 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/practice-sem-2/user-service/internal/models"
+	publisher "github.com/practice-sem-2/user-service/internal/publishers"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"time"
+)
+
+// newUserEvent builds event of the user's state after the change.
+// User is nil for deleted users
+func newUserEvent(eventType string, username string, user *models.User) (*models.Event, error) {
+	payload := models.UserEventPayload{
+		Type:       eventType,
+		Username:   username,
+		OccurredAt: time.Now().UTC(),
+	}
+	if user != nil {
+		payload.User = models.NewUserSnapshot(user)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Event{
+		Type:     eventType,
+		Username: username,
+		Payload:  data,
+	}, nil
+}
+
+// addUserEvent must be called inside the transaction that changes user.
+// Event goes both to outbox and to change feed of watchers
+func addUserEvent(ctx context.Context, store *storage.Storage, eventType string, username string, user *models.User) error {
+	event, err := newUserEvent(eventType, username, user)
+	if err != nil {
+		return err
+	}
+
+	if err = store.AddEvent(ctx, event); err != nil {
+		return err
+	}
+	return store.AddChange(ctx, event)
+}
+
+// OutboxRelay publishes events written to outbox by use cases
+type OutboxRelay struct {
+	store     *storage.Storage
+	publisher publisher.Publisher
+	batchSize uint64
+}
+
+func NewOutboxRelay(store *storage.Storage, publisher publisher.Publisher, batchSize uint64) *OutboxRelay {
+	return &OutboxRelay{
+		store:     store,
+		publisher: publisher,
+		batchSize: batchSize,
+	}
+}
+
+// Relay publishes one batch of the oldest events and returns how many were
+// published. Events are deleted only after they are published, so if relay
+// fails in between they will be published again. If another relay is
+// running, nothing is published, so events of one user never overtake
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	var published int
+	err := r.store.Atomic(ctx, func(store *storage.Storage) error {
+		locked, err := store.LockOutbox(ctx)
+		if err != nil || !locked {
+			return err
+		}
+
+		events, err := store.GetPendingEvents(ctx, r.batchSize)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		if err = r.publisher.Publish(ctx, events); err != nil {
+			return err
+		}
+
+		ids := make([]int64, len(events))
+		for i, event := range events {
+			ids[i] = event.ID
+		}
+		published = len(events)
+		return store.DeleteEvents(ctx, ids)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return published, nil
+}
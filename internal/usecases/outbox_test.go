@@ -0,0 +1,35 @@
+package usecase
+
+import (
+	"encoding/json"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewUserEvent_DoesNotExposePasswordHash(t *testing.T) {
+	user := &models.User{
+		Username:     "joe",
+		PasswordHash: "$argon2id$secret",
+		Email:        "joe@example.com",
+		IsActive:     true,
+	}
+
+	event, err := newUserEvent(models.EventUserActivated, user.Username, user)
+	assert.NoError(t, err)
+	assert.Equal(t, models.EventUserActivated, event.Type)
+	assert.Equal(t, "joe", event.Username)
+	assert.NotContains(t, string(event.Payload), "secret")
+
+	var payload models.UserEventPayload
+	if assert.NoError(t, json.Unmarshal(event.Payload, &payload)) {
+		assert.Equal(t, "joe@example.com", payload.User.Email)
+		assert.True(t, payload.User.IsActive)
+	}
+}
+
+func TestNewUserEvent_DeletedUserHasNoSnapshot(t *testing.T) {
+	event, err := newUserEvent(models.EventUserDeleted, "joe", nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(event.Payload), `"user"`)
+}
@@ -0,0 +1,119 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/user-service/internal/models"
+	notifier "github.com/practice-sem-2/user-service/internal/notifiers"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"time"
+)
+
+// RequestPasswordReset sends single-use password reset token to the user
+// found by either username or email. It succeeds silently if there is no
+// such user, token is sent in background and response is padded to the
+// same time either way, so it can't be used to find out which accounts exist
+func (u *UserUseCase) RequestPasswordReset(ctx context.Context, username *string, email *string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.RequestPasswordReset")
+	defer func() { endSpan(span, err) }()
+	defer waitUntil(ctx, time.Now().Add(u.config.PasswordResetDelay))
+
+	var user *models.User
+
+	if username != nil {
+		user, err = u.store.GetUserByUsername(ctx, *username)
+	} else if email != nil {
+		user, err = u.store.GetUserByEmail(ctx, *email)
+	} else {
+		return nil
+	}
+
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	err = u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		// Only the latest requested token is valid
+		err := store.DeletePasswordResetTokens(ctx, user.Username)
+		if err != nil {
+			return err
+		}
+
+		err = store.CreatePasswordResetToken(ctx, user.Username, hashCode(u.config.CodeSecret, token), u.config.PasswordResetTTL)
+		if err != nil {
+			return err
+		}
+
+		return addAuditEvent(ctx, store, models.AuditPasswordResetRequested, user.Username)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// Delivery error would tell that account exists, user can request token again
+	u.notifyDetached(ctx, notifier.Notification{
+		Kind:     notifier.KindPasswordReset,
+		Username: user.Username,
+		Email:    user.Email,
+		Code:     token,
+	})
+	return nil
+}
+
+// waitUntil blocks until deadline or until ctx is done
+func waitUntil(ctx context.Context, deadline time.Time) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// ConfirmPasswordReset redeems reset token, sets new password and
+// revokes all sessions. Password is expected to be validated by caller
+func (u *UserUseCase) ConfirmPasswordReset(ctx context.Context, token string, password string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.ConfirmPasswordReset")
+	defer func() { endSpan(span, err) }()
+
+	hash, err := u.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	return u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		resetToken, err := store.GetPasswordResetToken(ctx, hashCode(u.config.CodeSecret, token))
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			return ErrInvalidResetToken
+		} else if err != nil {
+			return err
+		}
+
+		if time.Now().After(resetToken.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+
+		_, err = store.UpdateUser(ctx, resetToken.Username, models.UpdateFields{Password: &hash})
+		if err != nil {
+			return err
+		}
+		// Somebody who knows old password may still have a session
+		if err = store.RevokeUserRefreshTokens(ctx, resetToken.Username); err != nil {
+			return err
+		}
+
+		if err = store.DeletePasswordResetTokens(ctx, resetToken.Username); err != nil {
+			return err
+		}
+		return addAuditEvent(ctx, store, models.AuditPasswordReset, resetToken.Username, secretChange("password", false))
+	})
+}
@@ -0,0 +1,80 @@
+package usecase
+
+import (
+	"context"
+	"github.com/practice-sem-2/user-service/internal/models"
+	notifier "github.com/practice-sem-2/user-service/internal/notifiers"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWaitUntil_WaitsForDeadline(t *testing.T) {
+	start := time.Now()
+	waitUntil(context.Background(), start.Add(50*time.Millisecond))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	start = time.Now()
+	waitUntil(context.Background(), start.Add(-time.Second))
+	assert.Less(t, time.Since(start), 50*time.Millisecond, "Should return at once when deadline has passed")
+}
+
+func TestWaitUntil_StopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	waitUntil(ctx, start.Add(time.Minute))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// resetStore knows only joe and pretends to save tokens
+type resetStore struct {
+	UserStore
+}
+
+func (s resetStore) GetUserByUsername(ctx context.Context, username string, columns ...string) (*models.User, error) {
+	if username != "joe" {
+		return nil, storage.ErrUserNotFound
+	}
+	return &models.User{Username: username, Email: "joe@example.com"}, nil
+}
+
+func (s resetStore) AtomicRetry(ctx context.Context, fn func(store *storage.Storage) error) error {
+	return nil
+}
+
+// slowNotifier delivers notifications only when released
+type slowNotifier struct {
+	release   chan struct{}
+	delivered chan notifier.Notification
+}
+
+func (n slowNotifier) Notify(ctx context.Context, notification notifier.Notification) error {
+	<-n.release
+	n.delivered <- notification
+	return nil
+}
+
+func TestRequestPasswordReset_DoesNotWaitForDelivery(t *testing.T) {
+	n := slowNotifier{release: make(chan struct{}), delivered: make(chan notifier.Notification, 1)}
+	config := DefaultConfig
+	config.PasswordResetDelay = 0
+	u := NewUserUseCase(resetStore{}, nil, n, nil, nil, config)
+
+	for _, username := range []string{"joe", "ann"} {
+		username := username
+		start := time.Now()
+		assert.NoError(t, u.RequestPasswordReset(context.Background(), &username, nil))
+		assert.Less(t, time.Since(start), time.Second, username)
+	}
+
+	close(n.release)
+	u.WaitNotifications()
+	if assert.Len(t, n.delivered, 1) {
+		notification := <-n.delivered
+		assert.Equal(t, "joe", notification.Username)
+		assert.NotEmpty(t, notification.Code)
+	}
+}
@@ -0,0 +1,214 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/user-service/internal/models"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"time"
+)
+
+type AccessTokenIssuer interface {
+	Issue(username string) (token string, expiresAt time.Time, err error)
+}
+
+type TokenPair struct {
+	AccessToken           string
+	AccessTokenExpiresAt  time.Time
+	RefreshToken          string
+	RefreshTokenExpiresAt time.Time
+}
+
+type SessionUseCase struct {
+	users  *UserUseCase
+	store  UserStore
+	issuer AccessTokenIssuer
+	config Config
+}
+
+func NewSessionUseCase(users *UserUseCase, store UserStore, issuer AccessTokenIssuer, config Config) *SessionUseCase {
+	return &SessionUseCase{
+		users:  users,
+		store:  store,
+		issuer: issuer,
+		config: config,
+	}
+}
+
+// Login checks credentials of active user and starts new session.
+// If user has 2FA enabled, SecondFactorRequiredError is returned and
+// session is started by VerifySecondFactor
+func (s *SessionUseCase) Login(ctx context.Context, username string, password string, clientIP string) (*models.User, *TokenPair, error) {
+	user, err := s.users.authenticate(ctx, username, password, clientIP, true)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil, nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	familyID, err := generateToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pair *TokenPair
+	err = s.store.Atomic(ctx, func(store *storage.Storage) error {
+		pair, err = s.issue(ctx, store, username, familyID)
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, pair, nil
+}
+
+// VerifySecondFactor completes authentication started by Login or
+// GetUserByCredentials. Tokens are issued only if it was started by Login
+func (s *SessionUseCase) VerifySecondFactor(ctx context.Context, challenge string, code string) (*models.User, *TokenPair, error) {
+	var user *models.User
+	var pair *TokenPair
+	// Failed attempt must be committed, so error is returned after transaction
+	var verifyErr error
+	err := s.store.Atomic(ctx, func(store *storage.Storage) error {
+		found, err := store.GetChallenge(ctx, hashCode(s.config.CodeSecret, challenge))
+		if errors.Is(err, storage.ErrChallengeNotFound) {
+			return ErrInvalidChallenge
+		} else if err != nil {
+			return err
+		}
+
+		if time.Now().After(found.ExpiresAt) {
+			return ErrInvalidChallenge
+		}
+
+		if found.Attempts >= s.config.ChallengeMaxAttempts {
+			return ErrChallengeExhausted
+		}
+
+		ok, err := s.users.checkSecondFactor(ctx, store, found.Username, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			verifyErr = ErrInvalidSecondFactor
+			return store.IncrementChallengeAttempts(ctx, found.ChallengeHash)
+		}
+
+		if err = store.DeleteChallenge(ctx, found.ChallengeHash); err != nil {
+			return err
+		}
+
+		user, err = store.GetUserByUsername(ctx, found.Username)
+		if err != nil {
+			return err
+		}
+
+		if !found.IssueTokens {
+			return nil
+		}
+		// User may have been deactivated since challenge was issued
+		if !user.IsActive {
+			return ErrUserNotActive
+		}
+
+		familyID, err := generateToken()
+		if err != nil {
+			return err
+		}
+		pair, err = s.issue(ctx, store, found.Username, familyID)
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+	if verifyErr != nil {
+		return nil, nil, verifyErr
+	}
+	return user, pair, nil
+}
+
+// Refresh exchanges refresh token for a new pair of tokens. Every refresh
+// token can be used only once. If already used token is presented, it is
+// considered stolen, so all tokens of its family are revoked
+func (s *SessionUseCase) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	var pair *TokenPair
+	// Revocation must be committed, so error is returned after transaction
+	var reuseErr error
+	err := s.store.Atomic(ctx, func(store *storage.Storage) error {
+		token, err := store.GetRefreshToken(ctx, hashCode(s.config.CodeSecret, refreshToken))
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			return ErrInvalidRefreshToken
+		} else if err != nil {
+			return err
+		}
+
+		if token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		if token.UsedAt != nil {
+			reuseErr = ErrRefreshTokenReused
+			return store.RevokeRefreshTokenFamily(ctx, token.FamilyID)
+		}
+
+		user, err := store.GetUserByUsername(ctx, token.Username)
+		if err != nil {
+			return err
+		}
+		if !user.IsActive {
+			return ErrUserNotActive
+		}
+
+		if err = store.MarkRefreshTokenUsed(ctx, token.TokenHash); err != nil {
+			return err
+		}
+
+		pair, err = s.issue(ctx, store, token.Username, token.FamilyID)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return pair, reuseErr
+}
+
+// Logout revokes refresh token together with all its predecessors and
+// successors. Access tokens stay valid until they expire
+func (s *SessionUseCase) Logout(ctx context.Context, refreshToken string) error {
+	return s.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		token, err := store.GetRefreshToken(ctx, hashCode(s.config.CodeSecret, refreshToken))
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return store.RevokeRefreshTokenFamily(ctx, token.FamilyID)
+	})
+}
+
+func (s *SessionUseCase) issue(ctx context.Context, store *storage.Storage, username string, familyID string) (*TokenPair, error) {
+	access, accessExpiresAt, err := s.issuer.Issue(username)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := store.CreateRefreshToken(ctx, username, hashCode(s.config.CodeSecret, refresh), familyID, s.config.RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:           access,
+		AccessTokenExpiresAt:  accessExpiresAt,
+		RefreshToken:          refresh,
+		RefreshTokenExpiresAt: created.ExpiresAt,
+	}, nil
+}
@@ -0,0 +1,318 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/practice-sem-2/user-service/internal/models"
+	otp "github.com/practice-sem-2/user-service/internal/otps"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"strings"
+	"time"
+)
+
+// totpSkew is how many time steps before and after
+// the current one are accepted to tolerate clock drift
+const totpSkew = 1
+
+type SecretCipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// SecondFactorRequiredError is returned instead of user when password is
+// correct, but user has 2FA enabled. Challenge must be passed to
+// VerifySecondFactor together with the code
+type SecondFactorRequiredError struct {
+	Challenge string
+	ExpiresAt time.Time
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return "second factor is required"
+}
+
+type TOTPEnrollment struct {
+	// Secret is encoded so that user can type it into authenticator app
+	Secret string
+	URI    string
+}
+
+// EnrollTOTP starts enrolment, which has to be confirmed with ConfirmTOTP.
+// Until then 2FA stays disabled and enrolment can be started over
+func (u *UserUseCase) EnrollTOTP(ctx context.Context, username string, password string, clientIP string) (_ *TOTPEnrollment, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.EnrollTOTP")
+	defer func() { endSpan(span, err) }()
+
+	if u.cipher == nil {
+		return nil, ErrTwoFactorUnavailable
+	}
+
+	if _, err := u.checkPassword(ctx, username, password, clientIP); err != nil {
+		return nil, err
+	}
+
+	secret, err := otp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := u.cipher.Encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.store.Atomic(ctx, func(store *storage.Storage) error {
+		totp, err := store.GetTOTP(ctx, username)
+		enrolled := err == nil
+		if enrolled && totp.Enabled() {
+			return ErrTwoFactorAlreadyEnabled
+		} else if err != nil && !errors.Is(err, storage.ErrTOTPNotFound) {
+			return err
+		}
+
+		if err = store.SetTOTP(ctx, username, encrypted); err != nil {
+			return err
+		}
+		return addAuditEvent(ctx, store, models.AuditTOTPEnrolled, username, secretChange("totp_secret", !enrolled))
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Secret: otp.EncodeSecret(secret),
+		URI:    otp.URI(secret, u.config.TOTPIssuer, username),
+	}, nil
+}
+
+// ConfirmTOTP enables 2FA if code matches enrolled secret and returns
+// recovery codes. They are stored hashed, so can't be shown again
+func (u *UserUseCase) ConfirmTOTP(ctx context.Context, username string, code string) (_ []string, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.ConfirmTOTP")
+	defer func() { endSpan(span, err) }()
+
+	if u.cipher == nil {
+		return nil, ErrTwoFactorUnavailable
+	}
+
+	var recoveryCodes []string
+	// Same as for DisableTOTP, failed attempt must be committed
+	var verifyErr error
+	err = u.store.Atomic(ctx, func(store *storage.Storage) error {
+		totp, err := store.GetTOTP(ctx, username)
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return ErrTwoFactorNotEnrolled
+		} else if err != nil {
+			return err
+		}
+
+		if totp.Enabled() {
+			return ErrTwoFactorAlreadyEnabled
+		}
+
+		attempts, err := u.checkSecondFactorAllowed(ctx, store, username)
+		if err != nil {
+			return err
+		}
+
+		step, ok, err := u.validateTOTP(totp, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			verifyErr = ErrInvalidSecondFactor
+			return u.recordSecondFactorFailure(ctx, store, attempts)
+		}
+
+		if err = store.ResetLoginAttempts(ctx, attemptsByTOTP, username); err != nil {
+			return err
+		}
+		if err = store.ConfirmTOTP(ctx, username, step); err != nil {
+			return err
+		}
+
+		recoveryCodes, err = u.replaceRecoveryCodes(ctx, store, username)
+		if err != nil {
+			return err
+		}
+
+		return addAuditEvent(ctx, store, models.AuditTOTPEnabled, username,
+			fieldChange("totp_enabled", boolValue(false), boolValue(true)),
+			secretChange("recovery_codes", true),
+		)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if verifyErr != nil {
+		return nil, verifyErr
+	}
+	return recoveryCodes, nil
+}
+
+// DisableTOTP turns 2FA off. Both password and
+// either TOTP or recovery code are required
+func (u *UserUseCase) DisableTOTP(ctx context.Context, username string, password string, code string, clientIP string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.DisableTOTP")
+	defer func() { endSpan(span, err) }()
+
+	if _, err := u.checkPassword(ctx, username, password, clientIP); err != nil {
+		return err
+	}
+
+	// Failed attempt must be committed, so error is returned after transaction
+	var verifyErr error
+	err = u.store.Atomic(ctx, func(store *storage.Storage) error {
+		ok, err := u.checkSecondFactor(ctx, store, username, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			verifyErr = ErrInvalidSecondFactor
+			return nil
+		}
+
+		if err = store.DeleteTOTP(ctx, username); err != nil {
+			return err
+		}
+		change := fieldChange("totp_enabled", boolValue(true), boolValue(false))
+		return addAuditEvent(ctx, store, models.AuditTOTPDisabled, username, change)
+	})
+
+	if err != nil {
+		return err
+	}
+	return verifyErr
+}
+
+// requireSecondFactor returns SecondFactorRequiredError
+// if user has 2FA enabled and nil otherwise
+func (u *UserUseCase) requireSecondFactor(ctx context.Context, username string, issueTokens bool) error {
+	challenge, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	var required *SecondFactorRequiredError
+	err = u.store.Atomic(ctx, func(store *storage.Storage) error {
+		totp, err := store.GetTOTP(ctx, username)
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !totp.Enabled() {
+			return nil
+		}
+
+		created, err := store.CreateChallenge(ctx, username, hashCode(u.config.CodeSecret, challenge), issueTokens, u.config.ChallengeTTL)
+		if err != nil {
+			return err
+		}
+
+		required = &SecondFactorRequiredError{
+			Challenge: challenge,
+			ExpiresAt: created.ExpiresAt,
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+	if required != nil {
+		return required
+	}
+	return nil
+}
+
+// checkSecondFactor is verifySecondFactor throttled per account.
+// Failures are recorded in store, so transaction must be committed
+func (u *UserUseCase) checkSecondFactor(ctx context.Context, store *storage.Storage, username string, code string) (bool, error) {
+	attempts, err := u.checkSecondFactorAllowed(ctx, store, username)
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := u.verifySecondFactor(ctx, store, username, code)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, u.recordSecondFactorFailure(ctx, store, attempts)
+	}
+	return true, store.ResetLoginAttempts(ctx, attemptsByTOTP, username)
+}
+
+// verifySecondFactor accepts either current TOTP code or unused recovery code
+func (u *UserUseCase) verifySecondFactor(ctx context.Context, store *storage.Storage, username string, code string) (bool, error) {
+	totp, err := store.GetTOTP(ctx, username)
+	if errors.Is(err, storage.ErrTOTPNotFound) {
+		return false, ErrTwoFactorNotEnrolled
+	} else if err != nil {
+		return false, err
+	}
+
+	if !totp.Enabled() {
+		return false, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok, err := u.validateTOTP(totp, code)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return true, store.SetTOTPLastUsedStep(ctx, username, step)
+	}
+
+	return store.UseRecoveryCode(ctx, username, hashCode(u.config.CodeSecret, normalizeRecoveryCode(code)))
+}
+
+// validateTOTP rejects codes that were already used
+func (u *UserUseCase) validateTOTP(totp *models.TOTP, code string) (int64, bool, error) {
+	if u.cipher == nil {
+		return 0, false, ErrTwoFactorUnavailable
+	}
+
+	secret, err := u.cipher.Decrypt(totp.SecretEncrypted)
+	if err != nil {
+		return 0, false, err
+	}
+
+	step, ok := otp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok || step <= totp.LastUsedStep {
+		return 0, false, nil
+	}
+	return step, true, nil
+}
+
+func (u *UserUseCase) replaceRecoveryCodes(ctx context.Context, store *storage.Storage, username string) ([]string, error) {
+	codes := make([]string, u.config.RecoveryCodes)
+	hashes := make([]string, u.config.RecoveryCodes)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(buf)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashCode(u.config.CodeSecret, code)
+	}
+
+	if err := store.ReplaceRecoveryCodes(ctx, username, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode lets users type code in any case and with or without dash
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
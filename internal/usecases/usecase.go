@@ -1,13 +1,115 @@
 package usecase
 
-import storage "github.com/practice-sem-2/user-service/internal/storages"
+import (
+	"errors"
+	hasher "github.com/practice-sem-2/user-service/internal/hashers"
+	notifier "github.com/practice-sem-2/user-service/internal/notifiers"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+var (
+	ErrUserAlreadyActive       = errors.New("user is already active")
+	ErrResendCooldown          = errors.New("activation code has been sent recently")
+	ErrActivationCodeExpired   = errors.New("activation code has expired")
+	ErrActivationCodeExhausted = errors.New("activation code has too many failed attempts")
+	ErrInvalidResetToken       = errors.New("password reset token is invalid or expired")
+	ErrWrongPassword           = errors.New("password is incorrect")
+	ErrInvalidEmailCode        = errors.New("email confirmation code is incorrect")
+	ErrEmailChangeExpired      = errors.New("email confirmation code has expired")
+	ErrEmailChangeExhausted    = errors.New("email confirmation code has too many failed attempts")
+	ErrInvalidCredentials      = errors.New("username or password is incorrect")
+	ErrUserNotActive           = errors.New("user is not activated")
+	ErrInvalidRefreshToken     = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused      = errors.New("refresh token has already been used")
+	ErrTwoFactorUnavailable    = errors.New("two-factor authentication is not configured")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two-factor authentication is not enabled")
+	ErrInvalidSecondFactor     = errors.New("second factor code is incorrect")
+	ErrInvalidChallenge        = errors.New("second factor challenge is invalid or expired")
+	ErrChallengeExhausted      = errors.New("second factor challenge has too many failed attempts")
+	ErrRestoreExpired          = errors.New("user was deleted too long ago to be restored")
+	ErrPasswordNotUpdatable    = errors.New("password can be changed only with current password")
+)
+
+type Config struct {
+	// CodeSecret is a key used to hash one-time codes at rest
+	CodeSecret               string
+	ActivationCodeTTL        time.Duration
+	ActivationResendCooldown time.Duration
+	ActivationMaxAttempts    int
+	PasswordResetTTL         time.Duration
+	EmailChangeTTL           time.Duration
+	EmailChangeMaxAttempts   int
+	RefreshTokenTTL          time.Duration
+	Lockout                  LockoutConfig
+	TOTPIssuer               string
+	ChallengeTTL             time.Duration
+	ChallengeMaxAttempts     int
+	RecoveryCodes            int
+	// WatchBuffer is how many changes a watcher may lag behind before it is dropped
+	WatchBuffer int
+	// DeletionGracePeriod is how long deleted user can be restored before it is purged
+	DeletionGracePeriod time.Duration
+	// ServiceRoles are roles of services by identity from their client certificates
+	ServiceRoles map[string][]string
+	// PasswordResetDelay is the least time RequestPasswordReset takes, so
+	// that delivery time doesn't reveal whether account exists
+	PasswordResetDelay time.Duration
+}
+
+var DefaultConfig = Config{
+	ActivationCodeTTL:        24 * time.Hour,
+	ActivationResendCooldown: time.Minute,
+	ActivationMaxAttempts:    5,
+	PasswordResetTTL:         time.Hour,
+	PasswordResetDelay:       time.Second,
+	EmailChangeTTL:           24 * time.Hour,
+	EmailChangeMaxAttempts:   5,
+	RefreshTokenTTL:          30 * 24 * time.Hour,
+	Lockout:                  DefaultLockoutConfig,
+	TOTPIssuer:               "user-service",
+	ChallengeTTL:             5 * time.Minute,
+	ChallengeMaxAttempts:     5,
+	RecoveryCodes:            10,
+	WatchBuffer:              256,
+	DeletionGracePeriod:      30 * 24 * time.Hour,
+}
+
+// notifyTimeout bounds delivery of notifications sent in background
+const notifyTimeout = time.Minute
+
+var tracer = otel.Tracer("github.com/practice-sem-2/user-service/internal/usecases")
 
 type UseCase struct {
-	Users *UserUseCase
+	Users    *UserUseCase
+	Sessions *SessionUseCase
+	Changes  *ChangeFeed
+	Access   *AccessUseCase
+	Audit    *AuditUseCase
 }
 
-func NewUseCase(store *storage.Storage) *UseCase {
+func NewUseCase(store *storage.Storage, passwordHasher hasher.PasswordHasher, notifier notifier.Notifier, cipher SecretCipher, issuer AccessTokenIssuer, counters Counters, config Config) *UseCase {
+	users := NewUserUseCase(store, passwordHasher, notifier, cipher, counters, config)
 	return &UseCase{
-		Users: NewUserUseCase(store),
+		Users:    users,
+		Sessions: NewSessionUseCase(users, store, issuer, config),
+		Changes:  NewChangeFeed(store, config.WatchBuffer),
+		Access:   NewAccessUseCase(store, config.ServiceRoles),
+		Audit:    NewAuditUseCase(store),
+	}
+}
+
+// endSpan records error returned by use case on its span. Second factor
+// requirement is a step of authentication, not a failure
+func endSpan(span trace.Span, err error) {
+	var required *SecondFactorRequiredError
+	if err != nil && !errors.As(err, &required) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	span.End()
 }
@@ -0,0 +1,31 @@
+package usecase
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestEndSpan_RecordsError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "failed")
+	endSpan(span, ErrWrongPassword)
+	_, span = tracer.Start(context.Background(), "second factor")
+	endSpan(span, &SecondFactorRequiredError{})
+	_, span = tracer.Start(context.Background(), "succeeded")
+	endSpan(span, nil)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 3) {
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+		assert.Equal(t, ErrWrongPassword.Error(), spans[0].Status().Description)
+		assert.Len(t, spans[0].Events(), 1, "error is recorded as event")
+		assert.Equal(t, codes.Unset, spans[1].Status().Code)
+		assert.Equal(t, codes.Unset, spans[2].Status().Code)
+	}
+}
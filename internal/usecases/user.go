@@ -2,78 +2,488 @@ package usecase
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
+	hasher "github.com/practice-sem-2/user-service/internal/hashers"
 	"github.com/practice-sem-2/user-service/internal/models"
+	notifier "github.com/practice-sem-2/user-service/internal/notifiers"
+	request "github.com/practice-sem-2/user-service/internal/requests"
 	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
 )
 
 type UserCRUD interface {
 	CreateUser(ctx context.Context, create *models.UserCreate) (*models.User, error)
-	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
-	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string, columns ...string) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string, columns ...string) (*models.User, error)
 	UpdateUser(ctx context.Context, username string, fields models.UpdateFields) (*models.User, error)
 	DeleteUser(ctx context.Context, username string) error
-	GetManyUsers(ctx context.Context, usernames []string) ([]models.User, error)
-	ActivateUser(ctx context.Context, username string, code string) error
+	GetManyUsers(ctx context.Context, usernames []string, columns ...string) ([]models.User, error)
+	ListUsers(ctx context.Context, query models.ListUsersQuery) ([]models.User, error)
+	SearchUsers(ctx context.Context, query models.SearchUsersQuery) ([]models.UserMatch, error)
+	ActivateUser(ctx context.Context, username string) error
 }
 
-type UserUseCase struct {
-	store UserCRUD
+type LoginAttempts interface {
+	GetLoginAttempts(ctx context.Context, kind string, subject string, forUpdate bool) (*models.LoginAttempts, error)
+	ResetLoginAttempts(ctx context.Context, kind string, subject string) error
 }
 
-func NewUserUseCase(store UserCRUD) *UserUseCase {
-	return &UserUseCase{store: store}
+type UserStore interface {
+	UserCRUD
+	LoginAttempts
+	Atomic(ctx context.Context, fn func(store *storage.Storage) error) error
+	// AtomicRetry may call fn several times, so fn must not notify
+	// users or have any other effects outside of the transaction
+	AtomicRetry(ctx context.Context, fn func(store *storage.Storage) error) error
 }
 
-func (u *UserUseCase) Create(ctx context.Context, user *models.UserCreate) (*models.User, error) {
-	user.Password = hashPassword(user.Password)
-	createdUser, err := u.store.CreateUser(ctx, user)
-	return createdUser, err
+type UserUseCase struct {
+	store    UserStore
+	hasher   hasher.PasswordHasher
+	notifier notifier.Notifier
+	// cipher encrypts TOTP secrets, 2FA is unavailable if it is nil
+	cipher   SecretCipher
+	counters Counters
+	config   Config
+	// dummyHash is verified when user doesn't exist
+	dummyHash     string
+	dummyHashOnce sync.Once
+	// notifications are being sent in background
+	notifications sync.WaitGroup
 }
 
-func hashPassword(password string) string {
-	hasher := sha256.New()
-	return hex.EncodeToString(hasher.Sum([]byte(password)))
+func NewUserUseCase(store UserStore, passwordHasher hasher.PasswordHasher, notifier notifier.Notifier, cipher SecretCipher, counters Counters, config Config) *UserUseCase {
+	if counters == nil {
+		counters = nopCounters{}
+	}
+	return &UserUseCase{
+		store:    store,
+		hasher:   passwordHasher,
+		notifier: notifier,
+		cipher:   cipher,
+		counters: counters,
+		config:   config,
+	}
 }
 
-func verifyPassword(password string, hash string) bool {
-	return hashPassword(password) == hash
+// notifyDetached sends notification in background, so that response
+// doesn't depend on delivery. Delivery errors are only logged
+func (u *UserUseCase) notifyDetached(ctx context.Context, n notifier.Notification) {
+	ctx = detach(ctx)
+	u.notifications.Add(1)
+	go func() {
+		defer u.notifications.Done()
+		ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		defer cancel()
+
+		if err := u.notifier.Notify(ctx, n); err != nil {
+			request.Logger(ctx).WithError(err).WithField("kind", n.Kind).Error("can't deliver notification")
+		}
+	}()
 }
 
-func (u *UserUseCase) GetByUsername(ctx context.Context, username string) (*models.User, error) {
-	return u.store.GetUserByUsername(ctx, username)
+// WaitNotifications blocks until notifications sent in background are
+// delivered or given up. Use cases must not be called after it
+func (u *UserUseCase) WaitNotifications() {
+	u.notifications.Wait()
 }
 
-func (u *UserUseCase) GetByEmail(ctx context.Context, email string) (*models.User, error) {
-	return u.store.GetUserByEmail(ctx, email)
+// detach returns context with request ID, logger and trace of ctx,
+// which is not canceled when ctx is
+func detach(ctx context.Context) context.Context {
+	detached := request.WithID(context.Background(), request.ID(ctx))
+	detached = request.WithLogger(detached, request.Logger(ctx))
+	return trace.ContextWithSpanContext(detached, trace.SpanContextFromContext(ctx))
 }
 
-func (u *UserUseCase) GetMany(ctx context.Context, usernames []string) ([]models.User, error) {
-	return u.store.GetManyUsers(ctx, usernames)
+func (u *UserUseCase) Create(ctx context.Context, user *models.UserCreate) (_ *models.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.Create")
+	defer func() { endSpan(span, err) }()
+
+	hash, err := u.hasher.Hash(user.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hash
+
+	var createdUser *models.User
+	var activation *notifier.Notification
+	err = u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		createdUser, err = store.CreateUser(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		if err = addUserEvent(ctx, store, models.EventUserCreated, createdUser.Username, createdUser); err != nil {
+			return err
+		}
+		if err = addAuditEvent(ctx, store, models.AuditUserCreated, createdUser.Username, diffUsers(nil, createdUser)...); err != nil {
+			return err
+		}
+		activation, err = u.issueActivationCode(ctx, store, createdUser)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	u.counters.Signup()
+
+	// User is already created, if code can't be delivered it can be resent
+	if err = u.notifier.Notify(ctx, *activation); err != nil {
+		request.Logger(ctx).WithError(err).Error("can't deliver activation code")
+	}
+	return createdUser, nil
 }
 
-func (u *UserUseCase) GetUserByCredentials(ctx context.Context, username string, password string) (*models.User, error) {
-	user, err := u.GetByUsername(ctx, username)
+// ResendActivationCode revokes all previously issued activation codes
+// and sends a new one, but not more often than once per cooldown
+func (u *UserUseCase) ResendActivationCode(ctx context.Context, username string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.ResendActivationCode")
+	defer func() { endSpan(span, err) }()
+
+	var activation *notifier.Notification
+	err = u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		// Lock user to serialize concurrent resend requests
+		user, err := store.GetUserForUpdate(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		if user.IsActive {
+			return ErrUserAlreadyActive
+		}
+
+		last, err := store.GetLastActivationCode(ctx, username)
+		if err != nil && !errors.Is(err, storage.ErrCodeNotFound) {
+			return err
+		}
+		if last != nil && time.Since(last.CreatedAt) < u.config.ActivationResendCooldown {
+			return ErrResendCooldown
+		}
+
+		if err = store.DeleteActivationCodes(ctx, username); err != nil {
+			return err
+		}
+		if err = addAuditEvent(ctx, store, models.AuditActivationCodeSent, username); err != nil {
+			return err
+		}
+		activation, err = u.issueActivationCode(ctx, store, user)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+	return u.notifier.Notify(ctx, *activation)
+}
+
+// issueActivationCode stores hash of a new code and returns notification
+// with the code itself. It must be sent only after transaction commits,
+// otherwise user could get code which doesn't exist
+func (u *UserUseCase) issueActivationCode(ctx context.Context, store *storage.Storage, user *models.User) (*notifier.Notification, error) {
+	code, err := generateCode(activationCodeDigits)
+	if err != nil {
+		return nil, err
+	}
 
+	_, err = store.CreateActivationCode(ctx, user.Username, hashCode(u.config.CodeSecret, code), u.config.ActivationCodeTTL)
 	if err != nil {
 		return nil, err
 	}
-	if verifyPassword(password, user.PasswordHash) {
-		return user, nil
-	} else {
+
+	return &notifier.Notification{
+		Kind:     notifier.KindActivationCode,
+		Username: user.Username,
+		Email:    user.Email,
+		Code:     code,
+	}, nil
+}
+
+// GetByUsername returns user with only provided fields
+// filled in, or all of them if none provided
+func (u *UserUseCase) GetByUsername(ctx context.Context, username string, fields ...string) (_ *models.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.GetByUsername")
+	defer func() { endSpan(span, err) }()
+
+	return u.store.GetUserByUsername(ctx, username, fields...)
+}
+
+func (u *UserUseCase) GetByEmail(ctx context.Context, email string, fields ...string) (_ *models.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.GetByEmail")
+	defer func() { endSpan(span, err) }()
+
+	return u.store.GetUserByEmail(ctx, email, fields...)
+}
+
+func (u *UserUseCase) GetMany(ctx context.Context, usernames []string, fields ...string) (_ []models.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.GetMany")
+	defer func() { endSpan(span, err) }()
+
+	return u.store.GetManyUsers(ctx, usernames, fields...)
+}
+
+// GetUserByCredentials returns SecondFactorRequiredError
+// instead of user if user has 2FA enabled
+func (u *UserUseCase) GetUserByCredentials(ctx context.Context, username string, password string, clientIP string) (_ *models.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.GetUserByCredentials")
+	defer func() { endSpan(span, err) }()
+
+	return u.authenticate(ctx, username, password, clientIP, false)
+}
+
+func (u *UserUseCase) authenticate(ctx context.Context, username string, password string, clientIP string, issueTokens bool) (*models.User, error) {
+	user, err := u.checkPassword(ctx, username, password, clientIP)
+
+	if errors.Is(err, ErrWrongPassword) || errors.Is(err, storage.ErrUserNotFound) {
+		u.counters.FailedLogin()
+		// Do not let anybody know that user exists
 		return nil, storage.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if u.hasher.NeedsRehash(user.PasswordHash) {
+		// Password is already verified, so failed rehash must not
+		// prevent user from logging in. It will be retried next time.
+		if err := u.rehashPassword(ctx, user, password); err != nil {
+			request.Logger(ctx).WithError(err).Warning("can't rehash password")
+		}
 	}
+
+	// Sessions are started only for active users, so
+	// they must not even get a second factor challenge
+	if issueTokens && !user.IsActive {
+		return nil, ErrUserNotActive
+	}
+
+	if err = u.requireSecondFactor(ctx, username, issueTokens); err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
-func (u *UserUseCase) Update(ctx context.Context, username string, fields models.UpdateFields) (*models.User, error) {
-	return u.store.UpdateUser(ctx, username, fields)
+// rehashPassword updates user in place
+func (u *UserUseCase) rehashPassword(ctx context.Context, user *models.User, password string) error {
+	hash, err := u.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	updated, err := u.store.UpdateUser(ctx, user.Username, models.UpdateFields{Password: &hash})
+	if err != nil {
+		return err
+	}
+	*user = *updated
+	return nil
 }
 
-func (u *UserUseCase) Activate(ctx context.Context, username, code string) error {
-	return u.store.ActivateUser(ctx, username, code)
+func (u *UserUseCase) Update(ctx context.Context, username string, fields models.UpdateFields) (_ *models.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.Update")
+	defer func() { endSpan(span, err) }()
+
+	// Otherwise stolen access token would be enough to take over the account
+	if fields.Password != nil {
+		return nil, ErrPasswordNotUpdatable
+	}
+
+	var user *models.User
+	err = u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		old, err := store.GetUserForUpdate(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		user, err = store.UpdateUser(ctx, username, fields)
+		if err != nil {
+			return err
+		}
+
+		if err = addAuditEvent(ctx, store, models.AuditUserUpdated, username, diffUsers(old, user)...); err != nil {
+			return err
+		}
+		if fields.Email == nil && fields.FirstName == nil && fields.LastName == nil && fields.AvatarID == nil {
+			return nil
+		}
+		return addUserEvent(ctx, store, models.EventUserUpdated, username, user)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (u *UserUseCase) Activate(ctx context.Context, username, code string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.Activate")
+	defer func() { endSpan(span, err) }()
+
+	// Failed attempts must be committed, so verification
+	// error is returned only after transaction is finished
+	var verifyErr error
+	err = u.store.Atomic(ctx, func(store *storage.Storage) error {
+		user, err := store.GetUserForUpdate(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		if user.IsActive {
+			return ErrUserAlreadyActive
+		}
+
+		last, err := store.GetLastActivationCode(ctx, username)
+		if errors.Is(err, storage.ErrCodeNotFound) {
+			return storage.ErrInvalidCode
+		} else if err != nil {
+			return err
+		}
+
+		if last.Attempts >= u.config.ActivationMaxAttempts {
+			return ErrActivationCodeExhausted
+		}
+
+		if time.Now().After(last.ExpiresAt) {
+			return ErrActivationCodeExpired
+		}
+
+		if !verifyCode(u.config.CodeSecret, code, last.CodeHash) {
+			verifyErr = storage.ErrInvalidCode
+			return store.IncrementActivationAttempts(ctx, last.ID)
+		}
+
+		if err = store.ActivateUser(ctx, username); err != nil {
+			return err
+		}
+
+		user.IsActive = true
+		if err = addUserEvent(ctx, store, models.EventUserActivated, username, user); err != nil {
+			return err
+		}
+		change := fieldChange("is_active", boolValue(false), boolValue(true))
+		if err = addAuditEvent(ctx, store, models.AuditUserActivated, username, change); err != nil {
+			return err
+		}
+		// No need to reactivate user, so delete all activation codes
+		return store.DeleteActivationCodes(ctx, username)
+	})
+
+	if err != nil {
+		return err
+	}
+	if verifyErr == nil {
+		u.counters.Activation()
+	}
+	return verifyErr
 }
 
-func (u *UserUseCase) Delete(ctx context.Context, username string) error {
-	return u.store.DeleteUser(ctx, username)
+// Delete hides user and ends all its sessions. User can be
+// restored until grace period is over and then it is purged
+func (u *UserUseCase) Delete(ctx context.Context, username string) (err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.Delete")
+	defer func() { endSpan(span, err) }()
+
+	err = u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		if err := store.DeleteUser(ctx, username); err != nil {
+			return err
+		}
+
+		if err := store.RevokeUserRefreshTokens(ctx, username); err != nil {
+			return err
+		}
+		if err := addAuditEvent(ctx, store, models.AuditUserDeleted, username); err != nil {
+			return err
+		}
+		return addUserEvent(ctx, store, models.EventUserDeleted, username, nil)
+	})
+
+	if err != nil {
+		return err
+	}
+	u.counters.Delete()
+	return nil
+}
+
+func (u *UserUseCase) Restore(ctx context.Context, username string) (_ *models.User, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.Restore")
+	defer func() { endSpan(span, err) }()
+
+	var user *models.User
+	err = u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+		deleted, err := store.GetDeletedUserForUpdate(ctx, username)
+		if err != nil {
+			return err
+		}
+
+		if !u.config.restorable(*deleted.DeletedAt, time.Now()) {
+			return ErrRestoreExpired
+		}
+
+		user, err = store.RestoreUser(ctx, username)
+		if err != nil {
+			return err
+		}
+		if err = addAuditEvent(ctx, store, models.AuditUserRestored, username); err != nil {
+			return err
+		}
+		return addUserEvent(ctx, store, models.EventUserRestored, username, user)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// restorable tells whether user deleted at deletedAt is still in grace period
+func (c Config) restorable(deletedAt time.Time, now time.Time) bool {
+	return !now.After(deletedAt.Add(c.DeletionGracePeriod))
+}
+
+// purgeBefore returns time, users deleted before which are not restorable
+func (c Config) purgeBefore(now time.Time) time.Time {
+	return now.Add(-c.DeletionGracePeriod)
+}
+
+// purgeBatch is how many users are purged in one transaction
+const purgeBatch = 100
+
+// PurgeDeleted permanently removes users whose grace period is over
+// and returns how many were removed
+func (u *UserUseCase) PurgeDeleted(ctx context.Context) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "UserUseCase.PurgeDeleted")
+	defer func() { endSpan(span, err) }()
+
+	before := u.config.purgeBefore(time.Now())
+	purged := 0
+	for {
+		var usernames []string
+		err := u.store.AtomicRetry(ctx, func(store *storage.Storage) error {
+			var err error
+			usernames, err = store.PurgeDeletedUsers(ctx, before, purgeBatch)
+			if err != nil {
+				return err
+			}
+
+			for _, username := range usernames {
+				if err = addUserEvent(ctx, store, models.EventUserPurged, username, nil); err != nil {
+					return err
+				}
+				if err = addAuditEvent(ctx, store, models.AuditUserPurged, username); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		if err != nil {
+			return purged, err
+		}
+
+		purged += len(usernames)
+		if len(usernames) < purgeBatch {
+			return purged, nil
+		}
+	}
 }
@@ -0,0 +1,81 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/user-service/internal/models"
+	notifier "github.com/practice-sem-2/user-service/internal/notifiers"
+	storage "github.com/practice-sem-2/user-service/internal/storages"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// failingStore fails every transaction as if COMMIT failed
+type failingStore struct {
+	UserStore
+}
+
+var errCommit = errors.New("commit failed")
+
+func (s failingStore) AtomicRetry(ctx context.Context, fn func(store *storage.Storage) error) error {
+	return errCommit
+}
+
+type recordingNotifier struct {
+	notified []notifier.Notification
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification notifier.Notification) error {
+	n.notified = append(n.notified, notification)
+	return nil
+}
+
+func TestUserUseCase_DoesNotSendActivationCodeUnlessCommitted(t *testing.T) {
+	n := &recordingNotifier{}
+	u := NewUserUseCase(failingStore{}, &countingHasher{}, n, nil, nil, DefaultConfig)
+
+	_, err := u.Create(context.Background(), &models.UserCreate{Username: "joe", Password: "secret"})
+	assert.ErrorIs(t, err, errCommit)
+	assert.ErrorIs(t, u.ResendActivationCode(context.Background(), "joe"), errCommit)
+	assert.Empty(t, n.notified)
+}
+
+func TestConfig_RestorableDuringGracePeriod(t *testing.T) {
+	c := DefaultConfig
+	now := time.Now()
+
+	assert.True(t, c.restorable(now.Add(-time.Minute), now))
+	assert.True(t, c.restorable(now.Add(-c.DeletionGracePeriod), now), "Should be restorable until grace period ends")
+	assert.False(t, c.restorable(now.Add(-c.DeletionGracePeriod-time.Second), now))
+}
+
+func TestConfig_PurgesOnlyPastCutoff(t *testing.T) {
+	c := DefaultConfig
+	now := time.Now()
+	before := c.purgeBefore(now)
+
+	purged := func(deletedAt time.Time) bool {
+		// The same condition as PurgeDeletedUsers query
+		return deletedAt.Before(before)
+	}
+
+	for _, deletedAt := range []time.Time{
+		now,
+		now.Add(-time.Hour),
+		now.Add(-c.DeletionGracePeriod),
+		now.Add(-c.DeletionGracePeriod - time.Second),
+		now.Add(-2 * c.DeletionGracePeriod),
+	} {
+		assert.NotEqual(t, c.restorable(deletedAt, now), purged(deletedAt),
+			"User deleted %s ago must be either restorable or purged", now.Sub(deletedAt))
+	}
+}
+
+func TestConfig_WithoutGracePeriod(t *testing.T) {
+	c := Config{DeletionGracePeriod: 0}
+	now := time.Now()
+
+	assert.False(t, c.restorable(now.Add(-time.Second), now))
+	assert.Equal(t, now, c.purgeBefore(now))
+}
@@ -0,0 +1,238 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"sync"
+	"time"
+)
+
+// changesBatch is how many changes are read from database at once
+const changesBatch = 500
+
+var (
+	ErrResumeExpired  = errors.New("changes after requested sequence number are no longer kept")
+	ErrWatcherTooSlow = errors.New("watcher does not keep up with changes")
+	ErrFeedClosed     = errors.New("change feed is closed")
+)
+
+type ChangeStore interface {
+	GetChangesSince(ctx context.Context, seq int64, usernames []string, limit uint64) ([]models.Change, error)
+	GetChangeSeqRange(ctx context.Context) (oldest int64, latest int64, err error)
+	DeleteChangesBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// ChangeFeed reads committed changes once per replica
+// and fans them out to all watchers connected to it
+type ChangeFeed struct {
+	store  ChangeStore
+	buffer int
+	done   chan struct{}
+	close  sync.Once
+
+	mu       sync.Mutex
+	seq      int64
+	started  bool
+	watchers map[*watcher]struct{}
+}
+
+type watcher struct {
+	usernames map[string]struct{}
+	// changes are closed when watcher is too slow
+	changes chan models.Change
+}
+
+func (w *watcher) wants(change models.Change) bool {
+	if w.usernames == nil {
+		return true
+	}
+	_, ok := w.usernames[change.Username]
+	return ok
+}
+
+// NewChangeFeed creates feed, where every watcher may lag
+// behind by at most buffer changes before it is dropped
+func NewChangeFeed(store ChangeStore, buffer int) *ChangeFeed {
+	return &ChangeFeed{
+		store:    store,
+		buffer:   buffer,
+		done:     make(chan struct{}),
+		watchers: make(map[*watcher]struct{}),
+	}
+}
+
+// Close stops all watchers, otherwise they would
+// prevent server from shutting down gracefully
+func (f *ChangeFeed) Close() {
+	f.close.Do(func() {
+		close(f.done)
+	})
+}
+
+// Poll sends changes committed since the previous poll to watchers.
+// It must be called whenever a change is notified and also from time
+// to time, because notifications may be lost during reconnects.
+// It must not be called concurrently
+func (f *ChangeFeed) Poll(ctx context.Context) error {
+	f.mu.Lock()
+	started, seq := f.started, f.seq
+	f.mu.Unlock()
+
+	if !started {
+		// Watchers replay older changes from database on their own
+		_, latest, err := f.store.GetChangeSeqRange(ctx)
+		if err != nil {
+			return err
+		}
+
+		f.mu.Lock()
+		f.seq, f.started = latest, true
+		f.mu.Unlock()
+		seq = latest
+	}
+
+	for {
+		changes, err := f.store.GetChangesSince(ctx, seq, nil, changesBatch)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			f.broadcast(change)
+			seq = change.Seq
+		}
+
+		if len(changes) < changesBatch {
+			return nil
+		}
+	}
+}
+
+func (f *ChangeFeed) broadcast(change models.Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for w := range f.watchers {
+		if !w.wants(change) {
+			continue
+		}
+		select {
+		case w.changes <- change:
+		default:
+			// Blocking here would delay every other watcher, so slow
+			// one is dropped and has to resume from its last change
+			close(w.changes)
+			delete(f.watchers, w)
+		}
+	}
+	f.seq = change.Seq
+}
+
+// Prune removes changes older than retention. Watchers can't resume after them
+func (f *ChangeFeed) Prune(ctx context.Context, retention time.Duration) (int64, error) {
+	return f.store.DeleteChangesBefore(ctx, time.Now().Add(-retention))
+}
+
+func (f *ChangeFeed) subscribe(usernames []string) (*watcher, int64) {
+	w := &watcher{changes: make(chan models.Change, f.buffer)}
+	if len(usernames) > 0 {
+		w.usernames = make(map[string]struct{}, len(usernames))
+		for _, username := range usernames {
+			w.usernames[username] = struct{}{}
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.watchers[w] = struct{}{}
+	return w, f.seq
+}
+
+func (f *ChangeFeed) unsubscribe(w *watcher) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.watchers[w]; ok {
+		delete(f.watchers, w)
+		close(w.changes)
+	}
+}
+
+// Watch calls send for every change of provided users, or of all users if
+// none provided, until ctx is done or error occurs. If since is provided,
+// changes after it are replayed first, otherwise only new ones are sent.
+// Every change is sent once and in order of sequence numbers
+func (f *ChangeFeed) Watch(ctx context.Context, since *int64, usernames []string, send func(change models.Change) error) error {
+	var cursor int64
+	if since != nil {
+		oldest, _, err := f.store.GetChangeSeqRange(ctx)
+		if err != nil {
+			return err
+		}
+		if oldest > 0 && *since < oldest-1 {
+			return ErrResumeExpired
+		}
+
+		// Watcher is subscribed only after replay, otherwise changes
+		// committed meanwhile would overflow its buffer
+		cursor = *since
+		if err = f.replay(ctx, &cursor, usernames, send); err != nil {
+			return err
+		}
+	}
+
+	w, seq := f.subscribe(usernames)
+	defer f.unsubscribe(w)
+
+	if since == nil {
+		cursor = seq
+	} else {
+		// Changes committed during the first replay are read once again,
+		// the ones after subscription are buffered as well
+		if err := f.replay(ctx, &cursor, usernames, send); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case change, ok := <-w.changes:
+			if !ok {
+				return ErrWatcherTooSlow
+			}
+			// Already sent during replay
+			if change.Seq <= cursor {
+				continue
+			}
+			if err := send(change); err != nil {
+				return err
+			}
+			cursor = change.Seq
+		case <-f.done:
+			return ErrFeedClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// replay sends changes after cursor up to the latest committed one
+func (f *ChangeFeed) replay(ctx context.Context, cursor *int64, usernames []string, send func(change models.Change) error) error {
+	for {
+		changes, err := f.store.GetChangesSince(ctx, *cursor, usernames, changesBatch)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			if err = send(change); err != nil {
+				return err
+			}
+			*cursor = change.Seq
+		}
+
+		if len(changes) < changesBatch {
+			return nil
+		}
+	}
+}
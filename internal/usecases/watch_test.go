@@ -0,0 +1,117 @@
+package usecase
+
+import (
+	"context"
+	"github.com/practice-sem-2/user-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryChanges keeps changes in order of sequence numbers
+type memoryChanges struct {
+	mu      sync.Mutex
+	changes []models.Change
+}
+
+func (m *memoryChanges) add(username string) models.Change {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	change := models.Change{Seq: int64(len(m.changes) + 1), Username: username}
+	m.changes = append(m.changes, change)
+	return change
+}
+
+func (m *memoryChanges) GetChangesSince(ctx context.Context, seq int64, usernames []string, limit uint64) ([]models.Change, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changes := append([]models.Change{}, m.changes[seq:]...)
+	if uint64(len(changes)) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+func (m *memoryChanges) GetChangeSeqRange(ctx context.Context) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return 1, int64(len(m.changes)), nil
+}
+
+func (m *memoryChanges) DeleteChangesBefore(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestChangeFeed_FiltersByUsername(t *testing.T) {
+	feed := NewChangeFeed(nil, 10)
+	w, _ := feed.subscribe([]string{"joe"})
+	defer feed.unsubscribe(w)
+
+	feed.broadcast(models.Change{Seq: 1, Username: "ann"})
+	feed.broadcast(models.Change{Seq: 2, Username: "joe"})
+
+	assert.Len(t, w.changes, 1)
+	assert.Equal(t, int64(2), (<-w.changes).Seq)
+}
+
+func TestChangeFeed_DropsSlowWatcher(t *testing.T) {
+	feed := NewChangeFeed(nil, 1)
+	slow, _ := feed.subscribe(nil)
+	fast, _ := feed.subscribe(nil)
+	defer feed.unsubscribe(fast)
+
+	feed.broadcast(models.Change{Seq: 1, Username: "joe"})
+	<-fast.changes
+	feed.broadcast(models.Change{Seq: 2, Username: "joe"})
+
+	// The first change is still buffered, then channel is closed
+	assert.Equal(t, int64(1), (<-slow.changes).Seq)
+	_, ok := <-slow.changes
+	assert.False(t, ok)
+
+	assert.Equal(t, int64(2), (<-fast.changes).Seq)
+	assert.NotPanics(t, func() { feed.unsubscribe(slow) })
+}
+
+func TestChangeFeed_SubscribeReturnsLastSeq(t *testing.T) {
+	feed := NewChangeFeed(nil, 1)
+	feed.broadcast(models.Change{Seq: 7, Username: "joe"})
+
+	w, seq := feed.subscribe(nil)
+	defer feed.unsubscribe(w)
+	assert.Equal(t, int64(7), seq)
+}
+
+func TestChangeFeed_ResumesWithMorePendingChangesThanBuffer(t *testing.T) {
+	const buffer = 2
+	store := &memoryChanges{}
+	feed := NewChangeFeed(store, buffer)
+	for i := 0; i < 5*buffer; i++ {
+		feed.broadcast(store.add("joe"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	since := int64(0)
+	var sent []int64
+	err := feed.Watch(ctx, &since, nil, func(change models.Change) error {
+		sent = append(sent, change.Seq)
+		// Changes keep coming while older ones are replayed
+		if len(sent) <= 3*buffer {
+			feed.broadcast(store.add("joe"))
+		}
+		if len(sent) == 8*buffer {
+			cancel()
+		}
+		return nil
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	if assert.Len(t, sent, 8*buffer) {
+		for i, seq := range sent {
+			assert.Equal(t, int64(i+1), seq)
+		}
+	}
+}
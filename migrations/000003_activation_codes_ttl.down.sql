@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP INDEX users_activation_codes_username_idx;
+
+ALTER TABLE users_activation_codes
+    DROP COLUMN created_at,
+    DROP COLUMN expires_at;
+
+END;
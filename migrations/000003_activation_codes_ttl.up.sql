@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE users_activation_codes
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN expires_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX users_activation_codes_username_idx ON users_activation_codes (username);
+
+END;
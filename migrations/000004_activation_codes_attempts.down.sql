@@ -0,0 +1,12 @@
+BEGIN;
+
+DELETE FROM users_activation_codes;
+
+ALTER TABLE users_activation_codes
+    DROP COLUMN id,
+    DROP COLUMN attempts;
+
+ALTER TABLE users_activation_codes
+    RENAME COLUMN code_hash TO code;
+
+END;
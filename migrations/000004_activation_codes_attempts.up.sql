@@ -0,0 +1,14 @@
+BEGIN;
+
+-- Codes are stored hashed from now on, so previously issued
+-- plain text codes can't be verified anymore and must be resent
+DELETE FROM users_activation_codes;
+
+ALTER TABLE users_activation_codes
+    RENAME COLUMN code TO code_hash;
+
+ALTER TABLE users_activation_codes
+    ADD COLUMN id       BIGSERIAL PRIMARY KEY,
+    ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+END;
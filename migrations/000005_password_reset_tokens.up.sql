@@ -0,0 +1,13 @@
+BEGIN;
+
+CREATE TABLE password_reset_tokens
+(
+    token_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+    username   VARCHAR(40) NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX password_reset_tokens_username_idx ON password_reset_tokens (username);
+
+END;
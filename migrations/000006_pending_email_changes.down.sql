@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE pending_email_changes;
+
+END;
@@ -0,0 +1,13 @@
+BEGIN;
+
+CREATE TABLE pending_email_changes
+(
+    username   VARCHAR(40) NOT NULL PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    new_email  VARCHAR(64) NOT NULL,
+    code_hash  VARCHAR(64) NOT NULL,
+    attempts   INTEGER     NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+END;
@@ -0,0 +1,18 @@
+BEGIN;
+
+CREATE TABLE refresh_tokens
+(
+    token_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+    username   VARCHAR(40) NOT NULL REFERENCES users ON DELETE CASCADE,
+    -- All tokens obtained by rotation from the same login share the family
+    family_id  VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ NULL DEFAULT NULL,
+    revoked_at TIMESTAMPTZ NULL DEFAULT NULL
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_username_idx ON refresh_tokens (username);
+
+END;
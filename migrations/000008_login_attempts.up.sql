@@ -0,0 +1,15 @@
+BEGIN;
+
+CREATE TABLE login_attempts
+(
+    -- Either 'user' or 'ip'
+    kind            VARCHAR(8)  NOT NULL,
+    subject         VARCHAR(64) NOT NULL,
+    failures        INTEGER     NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until    TIMESTAMPTZ NULL     DEFAULT NULL,
+
+    PRIMARY KEY (kind, subject)
+);
+
+END;
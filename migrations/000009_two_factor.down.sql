@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE second_factor_challenges;
+DROP TABLE users_recovery_codes;
+DROP TABLE users_totp;
+
+END;
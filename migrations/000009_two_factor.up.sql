@@ -0,0 +1,36 @@
+BEGIN;
+
+CREATE TABLE users_totp
+(
+    username         VARCHAR(40) NOT NULL PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    -- Encrypted with TOTP_ENCRYPTION_KEY
+    secret_encrypted BYTEA       NOT NULL,
+    -- 2FA is enabled only after the first code is confirmed
+    confirmed_at     TIMESTAMPTZ NULL     DEFAULT NULL,
+    -- Codes of this and earlier time steps can't be used again
+    last_used_step   BIGINT      NOT NULL DEFAULT 0,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE users_recovery_codes
+(
+    id        BIGSERIAL PRIMARY KEY,
+    username  VARCHAR(40) NOT NULL REFERENCES users ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at   TIMESTAMPTZ NULL DEFAULT NULL
+);
+
+CREATE INDEX users_recovery_codes_username_idx ON users_recovery_codes (username);
+
+CREATE TABLE second_factor_challenges
+(
+    challenge_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+    username       VARCHAR(40) NOT NULL REFERENCES users ON DELETE CASCADE,
+    -- Whether access and refresh tokens are issued after challenge is passed
+    issue_tokens   BOOLEAN     NOT NULL DEFAULT FALSE,
+    attempts       INTEGER     NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at     TIMESTAMPTZ NOT NULL
+);
+
+END;
@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP INDEX users_email_idx;
+DROP INDEX users_created_at_idx;
+
+ALTER TABLE users
+    DROP COLUMN created_at;
+
+END;
@@ -0,0 +1,11 @@
+BEGIN;
+
+-- Existing users get the time of migration
+ALTER TABLE users
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Keyset pagination orders by sort column and then by username
+CREATE INDEX users_created_at_idx ON users (created_at, username);
+CREATE INDEX users_email_idx ON users (email, username);
+
+END;
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX users_email_local_trgm_idx;
+DROP INDEX users_last_name_trgm_idx;
+DROP INDEX users_first_name_trgm_idx;
+DROP INDEX users_username_trgm_idx;
+
+END;
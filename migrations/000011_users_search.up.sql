@@ -0,0 +1,11 @@
+BEGIN;
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Trigram indexes serve both prefix ILIKE and similarity (%) lookups
+CREATE INDEX users_username_trgm_idx ON users USING GIN (username gin_trgm_ops);
+CREATE INDEX users_first_name_trgm_idx ON users USING GIN (first_name gin_trgm_ops);
+CREATE INDEX users_last_name_trgm_idx ON users USING GIN (last_name gin_trgm_ops);
+CREATE INDEX users_email_local_trgm_idx ON users USING GIN (split_part(email, '@', 1) gin_trgm_ops);
+
+END;
@@ -0,0 +1,15 @@
+BEGIN;
+
+-- Events are written in the same transaction as the change
+-- and deleted by relay after they are published
+CREATE TABLE outbox
+(
+    id         BIGSERIAL   NOT NULL PRIMARY KEY,
+    event_type VARCHAR(32) NOT NULL,
+    -- Events of one user are published in order of id
+    username   VARCHAR(40) NOT NULL,
+    payload    JSONB       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+END;
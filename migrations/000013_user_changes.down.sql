@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TRIGGER user_changes_notify ON user_changes;
+DROP FUNCTION notify_user_change();
+DROP TABLE user_changes;
+
+END;
@@ -0,0 +1,32 @@
+BEGIN;
+
+-- Change feed for watchers. Unlike outbox, changes are kept for
+-- a while, so watchers can resume from a sequence number
+CREATE TABLE user_changes
+(
+    seq        BIGSERIAL   NOT NULL PRIMARY KEY,
+    event_type VARCHAR(32) NOT NULL,
+    username   VARCHAR(40) NOT NULL,
+    payload    JSONB       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX user_changes_username_idx ON user_changes (username, seq);
+CREATE INDEX user_changes_created_at_idx ON user_changes (created_at);
+
+CREATE FUNCTION notify_user_change() RETURNS TRIGGER AS
+$$
+BEGIN
+    PERFORM pg_notify('user_changes', NEW.seq::TEXT);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Notifications are delivered on commit to every replica listening
+CREATE TRIGGER user_changes_notify
+    AFTER INSERT
+    ON user_changes
+    FOR EACH ROW
+EXECUTE PROCEDURE notify_user_change();
+
+END;
@@ -0,0 +1,11 @@
+BEGIN;
+
+-- Soft deleted users would become visible otherwise
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX users_deleted_at_idx;
+
+ALTER TABLE users
+    DROP COLUMN deleted_at;
+
+END;
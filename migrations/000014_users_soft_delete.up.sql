@@ -0,0 +1,10 @@
+BEGIN;
+
+-- Deleted users keep their username and email
+-- reserved until they are purged after grace period
+ALTER TABLE users
+    ADD COLUMN deleted_at TIMESTAMPTZ NULL DEFAULT NULL;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+END;
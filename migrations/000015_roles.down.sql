@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP TABLE user_roles;
+DROP TABLE role_permissions;
+DROP TABLE permissions;
+DROP TABLE roles;
+
+END;
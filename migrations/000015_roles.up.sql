@@ -0,0 +1,67 @@
+BEGIN;
+
+CREATE TABLE roles
+(
+    name        VARCHAR(32)  NOT NULL PRIMARY KEY,
+    description VARCHAR(256) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE permissions
+(
+    name        VARCHAR(64)  NOT NULL PRIMARY KEY,
+    description VARCHAR(256) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions
+(
+    role       VARCHAR(32) NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission VARCHAR(64) NOT NULL REFERENCES permissions ON DELETE CASCADE,
+
+    PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE user_roles
+(
+    username   VARCHAR(40) NOT NULL REFERENCES users ON DELETE CASCADE,
+    role       VARCHAR(32) NOT NULL REFERENCES roles ON DELETE CASCADE,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (username, role)
+);
+
+INSERT INTO permissions (name, description)
+VALUES ('users:read', 'Read any user'),
+       ('users:list', 'List all users'),
+       ('users:search', 'Search users'),
+       ('users:watch', 'Watch changes of users'),
+       ('users:authenticate', 'Check credentials of any user'),
+       ('users:update', 'Update any user'),
+       ('users:delete', 'Delete any user'),
+       ('users:restore', 'Restore deleted users'),
+       ('users:unlock', 'Unlock users locked after failed logins'),
+       ('roles:manage', 'Assign and revoke roles');
+
+-- Role "user" is implicitly held by every user
+INSERT INTO roles (name, description)
+VALUES ('user', 'Every authenticated user'),
+       ('service', 'Internal services'),
+       ('support', 'Support staff'),
+       ('admin', 'Administrators');
+
+INSERT INTO role_permissions (role, permission)
+VALUES ('user', 'users:search'),
+       ('service', 'users:read'),
+       ('service', 'users:list'),
+       ('service', 'users:search'),
+       ('service', 'users:watch'),
+       ('service', 'users:authenticate'),
+       ('support', 'users:read'),
+       ('support', 'users:list'),
+       ('support', 'users:search'),
+       ('support', 'users:unlock');
+
+INSERT INTO role_permissions (role, permission)
+SELECT 'admin', name
+FROM permissions;
+
+END;
@@ -0,0 +1,8 @@
+BEGIN;
+
+DELETE FROM permissions WHERE name = 'audit:read';
+
+DROP TABLE audit_events;
+DROP FUNCTION forbid_audit_event_change();
+
+END;
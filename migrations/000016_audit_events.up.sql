@@ -0,0 +1,42 @@
+BEGIN;
+
+-- Audit log is append-only. Rows of purged users are kept,
+-- so there is no foreign key to users
+CREATE TABLE audit_events
+(
+    id           BIGSERIAL    NOT NULL PRIMARY KEY,
+    actor        VARCHAR(300) NOT NULL,
+    username     VARCHAR(40)  NOT NULL,
+    action       VARCHAR(64)  NOT NULL,
+    changes      JSONB        NOT NULL DEFAULT '[]',
+    request_id   VARCHAR(128) NOT NULL DEFAULT '',
+    peer_address VARCHAR(64)  NOT NULL DEFAULT '',
+    occurred_at  TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+CREATE INDEX audit_events_username_idx ON audit_events (username, id);
+CREATE INDEX audit_events_actor_idx ON audit_events (actor, id);
+CREATE INDEX audit_events_action_idx ON audit_events (action, id);
+CREATE INDEX audit_events_occurred_at_idx ON audit_events (occurred_at);
+
+CREATE FUNCTION forbid_audit_event_change() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE
+    ON audit_events
+    FOR EACH STATEMENT
+EXECUTE PROCEDURE forbid_audit_event_change();
+
+INSERT INTO permissions (name, description)
+VALUES ('audit:read', 'Read audit log of all users');
+
+INSERT INTO role_permissions (role, permission)
+VALUES ('support', 'audit:read'),
+       ('admin', 'audit:read');
+
+END;